@@ -0,0 +1,102 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ignoredArchiveDirs holds the names of the directories not included in
+// charm archives.
+var ignoredArchiveDirs = map[string]bool{
+	".git": true,
+	".bzr": true,
+	".hg":  true,
+}
+
+// archiveSHA256 returns the hex encoded SHA256 hash of a zip archive of the
+// charm in the given directory. The archive is deterministic: entries are
+// sorted by path and file modification times are not included, so that the
+// hash only changes when the charm contents or file modes change.
+func archiveSHA256(dir string) (string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && ignoredArchiveDirs[info.Name()] {
+			return filepath.SkipDir
+		}
+		if path != dir {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	zw := zip.NewWriter(h)
+	for _, path := range paths {
+		if err := addToArchive(zw, dir, path); err != nil {
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// addToArchive adds the file or directory at the given path to the zip
+// archive, using a name relative to the given base directory.
+func addToArchive(zw *zip.Writer, dir, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	header := &zip.FileHeader{
+		Name:   filepath.ToSlash(rel),
+		Method: zip.Deflate,
+	}
+	header.SetMode(info.Mode())
+	if info.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		return nil
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, target)
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -11,14 +11,170 @@ import (
 
 // FromData generates and returns the list of changes required to deploy the
 // given bundle data. The changes are sorted by requirements, so that they can
-// be applied in order. The bundle data is assumed to be already verified.
-func FromData(data *charm.BundleData) []Change {
+// be applied in order. The bundle data is assumed to be already verified
+// (see VerifyBundleData), but an error is returned if charms or placement directives cannot be
+// processed.
+func FromData(data *charm.BundleData) ([]Change, error) {
+	return FromDataWithModel(data, nil)
+}
+
+// FromDataWithModel generates and returns the list of changes required to
+// deploy the given bundle data onto a model with the given contents. Only the
+// changes for entities missing from the model are generated. Changes may
+// refer to existing model entities using their names or ids rather than
+// placeholders. A nil model is treated as an empty one.
+func FromDataWithModel(data *charm.BundleData, model *Model) ([]Change, error) {
+	return FromConfig(ChangesConfig{
+		Bundle: data,
+		Model:  model,
+	})
+}
+
+// ChangesConfig holds the parameters used to generate the changes required
+// to deploy a bundle.
+type ChangesConfig struct {
+	// Bundle holds the bundle data to be deployed. The bundle data is assumed
+	// to be already verified.
+	Bundle *charm.BundleData
+	// Model optionally holds the existing model contents. A nil model is
+	// treated as an empty one.
+	Model *Model
+	// Prune reports whether the entities present in the model but not
+	// declared in the bundle must be removed. This includes the annotations
+	// of existing applications and machines.
+	Prune bool
+	// UseExistingMachines reports whether bundle machines must be mapped to
+	// the model machines with the same ids, when present.
+	UseExistingMachines bool
+	// MachineMap optionally maps bundle machine ids to model machine ids.
+	// Explicit mappings take precedence over the ones implied by
+	// UseExistingMachines and over the ones inferred from the machines where
+	// existing units are deployed. Mapped machines must be declared in the
+	// bundle and present in the model, and each model machine can only be
+	// mapped to a single bundle machine.
+	MachineMap map[string]string
+	// ForceBindings reports whether the endpoints of existing applications
+	// must be rebound to different spaces even if the application units are
+	// already running.
+	ForceBindings bool
+	// CharmResolver optionally holds the resolver used to retrieve
+	// information about the charms referenced by the bundle. If not
+	// specified, local charms are looked up in the filesystem. Local charm
+	// paths are made absolute before being passed to the resolver.
+	CharmResolver CharmResolver
+	// BundleDir optionally holds the directory containing the bundle. Local
+	// charm paths, and the paths of the files included in option and
+	// annotation values using the "include-file://" and "include-base64://"
+	// prefixes, are relative to this directory, or to the current working
+	// directory if not specified.
+	BundleDir string
+	// Channel optionally holds the charm store channel (like "stable",
+	// "candidate" or "edge") charms are retrieved from.
+	Channel string
+	// ApplicationChannels optionally holds the charm store channels to be
+	// used for specific applications, keyed by application name, as declared
+	// by the "channel" bundle field (see ReadBundleExtras). These take
+	// precedence over Channel.
+	ApplicationChannels map[string]string
+	// TrustedApplications optionally holds the values of the "trust" bundle
+	// field (see ReadBundleExtras), keyed by application name. Applications
+	// mapped to true are granted access to the cloud credentials, while
+	// existing applications mapped to false have their access revoked.
+	// Applications not included are left as they are.
+	TrustedApplications map[string]bool
+	// PlacementConstraints optionally holds the names of the application
+	// constraints (like "mem" or "cores") applied to the machines and
+	// containers created to host the application units. If not specified,
+	// all the application constraints are applied.
+	PlacementConstraints []string
+	// Zones optionally holds the availability zones new machines are
+	// distributed across, in round-robin order. Machines placed with an
+	// explicit "zone=<zone>" directive, and bundle machines included in
+	// MachineZones, are not affected.
+	Zones []string
+	// MachineZones optionally holds the availability zones bundle machines
+	// are created in, keyed by bundle machine id, as declared by the "zone"
+	// machine field (see ReadBundleExtras).
+	MachineZones map[string]string
+	// PlacementPolicy optionally holds the policy used to place the units
+	// without placement directives. By default their placement is left to
+	// the provider.
+	PlacementPolicy PlacementPolicy
+	// UnitsPerMachine optionally holds the maximum number of units placed
+	// to each machine by the PackPlacement policy. Zero means no limit.
+	UnitsPerMachine int
+}
+
+// FromConfig generates and returns the list of changes required to deploy
+// the bundle described by the given configuration. The changes are sorted by
+// requirements, so that they can be applied in order. A *CharmError or a
+// *PlacementError is returned if an application charm or unit placement
+// directive cannot be processed, an *IncludeError if a file included in an
+// option or annotation cannot be read, a *SubordinateError if a subordinate
+// application is not valid, and a *SeriesErrors reporting all the units
+// placed to machines with a different series.
+func FromConfig(config ChangesConfig) ([]Change, error) {
+	switch config.PlacementPolicy {
+	case ProviderPlacement, SpreadPlacement, PackPlacement:
+	default:
+		return nil, fmt.Errorf("invalid placement policy %q", config.PlacementPolicy)
+	}
+	if config.UnitsPerMachine < 0 {
+		return nil, fmt.Errorf("invalid number of units per machine %d", config.UnitsPerMachine)
+	}
+	model := config.Model
+	if model == nil {
+		model = &Model{}
+	}
+	charmResolver := config.CharmResolver
+	if charmResolver == nil {
+		charmResolver = FilesystemCharmResolver{}
+	}
+	// Charms are resolved more than once while generating the changes.
+	charmResolver = NewCachingCharmResolver(charmResolver)
+	machineMap, err := model.machineMap(config.Bundle, config.UseExistingMachines, config.MachineMap)
+	if err != nil {
+		return nil, err
+	}
 	cs := &changeset{}
-	addedApplications := handleApplications(cs.add, data.Applications, data.Series)
-	addedMachines := handleMachines(cs.add, data.Machines, data.Series)
-	handleRelations(cs.add, data.Relations, addedApplications)
-	handleUnits(cs.add, data.Applications, addedApplications, addedMachines, data.Series)
-	return cs.sorted()
+	p := &planner{
+		add:                  cs.add,
+		bundle:               config.Bundle,
+		model:                model,
+		machineMap:           machineMap,
+		prune:                config.Prune,
+		forceBindings:        config.ForceBindings,
+		charmResolver:        charmResolver,
+		bundleDir:            config.BundleDir,
+		defaultChannel:       config.Channel,
+		channels:             config.ApplicationChannels,
+		trusted:              config.TrustedApplications,
+		subordinates:         make(map[string]bool),
+		placementConstraints: config.PlacementConstraints,
+		zones:                config.Zones,
+		machineZones:         config.MachineZones,
+		placementPolicy:      config.PlacementPolicy,
+		unitsPerMachine:      config.UnitsPerMachine,
+	}
+	if err := p.handleSubordinates(); err != nil {
+		return nil, err
+	}
+	if err := p.validateSeries(); err != nil {
+		return nil, err
+	}
+	addedApplications, err := p.handleApplications()
+	if err != nil {
+		return nil, err
+	}
+	addedMachines := p.handleMachines()
+	p.handleRelations(addedApplications)
+	if err := p.handleUnits(addedApplications, addedMachines); err != nil {
+		return nil, err
+	}
+	if config.Prune {
+		p.handlePrune()
+	}
+	return cs.sorted(), nil
 }
 
 // Change holds a single change required to deploy a bundle.
@@ -90,16 +246,59 @@ type AddCharmChange struct {
 
 // GUIArgs implements Change.GUIArgs.
 func (ch *AddCharmChange) GUIArgs() []interface{} {
-	return []interface{}{ch.Params.Charm, ch.Params.Series}
+	return []interface{}{ch.Params.Charm, ch.Params.Series, ch.Params.Channel}
 }
 
 // AddCharmParams holds parameters for adding a charm to the environment.
 type AddCharmParams struct {
-	// Charm holds the URL of the charm to be added.
+	// Charm holds the URL of the charm to be added, or the absolute path of
+	// the charm if this is a local charm not recognized by the charm
+	// resolver.
 	Charm string
 	// Series holds the series of the charm to be added
 	// if the charm default is not sufficient.
 	Series string
+	// Channel holds the charm store channel the charm is retrieved from, or
+	// an empty string if the default channel must be used.
+	Channel string
+}
+
+// newUploadCharmChange creates a new change for uploading a local charm.
+func newUploadCharmChange(params UploadCharmParams, requires ...string) *UploadCharmChange {
+	return &UploadCharmChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "uploadCharm",
+		},
+		Params: params,
+	}
+}
+
+// UploadCharmChange holds a change for uploading a local charm to the
+// environment. The placeholder pointing to this change must be replaced with
+// the "local:" URL of the uploaded charm.
+type UploadCharmChange struct {
+	changeInfo
+	// Params holds parameters for uploading a charm.
+	Params UploadCharmParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *UploadCharmChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Path, ch.Params.SHA256, ch.Params.Series}
+}
+
+// UploadCharmParams holds parameters for uploading a local charm to the
+// environment.
+type UploadCharmParams struct {
+	// Path holds the absolute path of the charm directory.
+	Path string
+	// SHA256 holds the hex encoded SHA256 hash of the charm archive. The
+	// archive is generated deterministically, so that the hash only depends
+	// on the charm contents.
+	SHA256 string
+	// Series holds the series the charm is uploaded for.
+	Series string
 }
 
 // newAddMachineChange creates a new change for adding a machine or container.
@@ -127,6 +326,7 @@ func (ch *AddMachineChange) GUIArgs() []interface{} {
 		Constraints:   ch.Params.Constraints,
 		ContainerType: ch.Params.ContainerType,
 		ParentId:      ch.Params.ParentId,
+		Zone:          ch.Params.Zone,
 	}
 	return []interface{}{options}
 }
@@ -141,6 +341,8 @@ type AddMachineOptions struct {
 	ContainerType string `json:"containerType,omitempty"`
 	// ParentId holds the id of the parent machine.
 	ParentId string `json:"parentId,omitempty"`
+	// Zone holds the availability zone the machine is created in.
+	Zone string `json:"zone,omitempty"`
 }
 
 // AddMachineParams holds parameters for adding a machine or container.
@@ -153,9 +355,14 @@ type AddMachineParams struct {
 	// ""lxc" or kvm"). It is not specified for top level machines.
 	ContainerType string
 	// ParentId optionally holds a placeholder pointing to another machine
-	// change or to a unit change. This value is only specified in the case
-	// this machine is a container, in which case also ContainerType is set.
+	// change or to a unit change, or the id of a machine already present in
+	// the model. This value is only specified in the case this machine is a
+	// container, in which case also ContainerType is set.
 	ParentId string
+	// Zone optionally holds the availability zone the machine must be
+	// created in. It is not specified for containers added to existing
+	// machines or units.
+	Zone string
 }
 
 // newAddRelationChange creates a new change for adding a relation.
@@ -184,9 +391,10 @@ func (ch *AddRelationChange) GUIArgs() []interface{} {
 // AddRelationParams holds parameters for adding a relation between two applications.
 type AddRelationParams struct {
 	// Endpoint1 and Endpoint2 hold relation endpoints in the
-	// "application:interface" form, where the application is always a placeholder
-	// pointing to an application change, and the interface is optional. Examples
-	// are "$deploy-42:web" or just "$deploy-42".
+	// "application:interface" form, where the application is either a
+	// placeholder pointing to an application change or the name of an
+	// application already present in the model, and the interface is optional.
+	// Examples are "$deploy-42:web", "$deploy-42" or "mysql:db".
 	Endpoint1 string
 	Endpoint2 string
 }
@@ -236,6 +444,7 @@ func (ch *AddApplicationChange) GUIArgs() []interface{} {
 		storage,
 		endpointBindings,
 		resources,
+		ch.Params.Channel,
 	}
 }
 
@@ -243,6 +452,9 @@ func (ch *AddApplicationChange) GUIArgs() []interface{} {
 type AddApplicationParams struct {
 	// Charm holds the URL of the charm to be used to deploy this application.
 	Charm string
+	// Channel holds the charm store channel the charm is retrieved from, or
+	// an empty string if the default channel must be used.
+	Channel string
 	// Series holds the series of the application to be deployed
 	// if the charm default is not sufficient.
 	Series string
@@ -290,10 +502,12 @@ func (ch *AddUnitChange) GUIArgs() []interface{} {
 
 // AddUnitParams holds parameters for adding an application unit.
 type AddUnitParams struct {
-	// Application holds the application placeholder name for which a unit is added.
+	// Application holds the application placeholder name for which a unit is
+	// added, or the name of the application if it already exists in the model.
 	Application string
 	// To holds the optional location where to add the unit, as a placeholder
-	// pointing to another unit change or to a machine change.
+	// pointing to another unit change or to a machine change, or as the id of
+	// a machine already present in the model.
 	To string
 }
 
@@ -322,8 +536,285 @@ func (ch *ExposeChange) GUIArgs() []interface{} {
 
 // ExposeParams holds parameters for exposing an application.
 type ExposeParams struct {
-	// Application holds the placeholder name of the application that must be exposed.
+	// Application holds the placeholder name of the application that must be
+	// exposed, or the name of the application if it already exists in the model.
+	Application string
+}
+
+// newTrustChange creates a new change for granting an application access to
+// the cloud credentials.
+func newTrustChange(params TrustParams, requires ...string) *TrustChange {
+	return &TrustChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "trust",
+		},
+		Params: params,
+	}
+}
+
+// TrustChange holds a change for granting an application access to the cloud
+// credentials.
+type TrustChange struct {
+	changeInfo
+	// Params holds parameters for trusting an application.
+	Params TrustParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *TrustChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Application}
+}
+
+// TrustParams holds parameters for trusting an application.
+type TrustParams struct {
+	// Application holds the placeholder name of the application that must be
+	// trusted, or the name of the application if it already exists in the model.
+	Application string
+}
+
+// newUntrustChange creates a new change for revoking the access of an
+// application to the cloud credentials.
+func newUntrustChange(params UntrustParams, requires ...string) *UntrustChange {
+	return &UntrustChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "untrust",
+		},
+		Params: params,
+	}
+}
+
+// UntrustChange holds a change for revoking the access of an existing
+// application to the cloud credentials.
+type UntrustChange struct {
+	changeInfo
+	// Params holds parameters for untrusting an application.
+	Params UntrustParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *UntrustChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Application}
+}
+
+// UntrustParams holds parameters for untrusting an application.
+type UntrustParams struct {
+	// Application holds the name of the application that must be untrusted.
+	Application string
+}
+
+// newUnexposeChange creates a new change for unexposing an application.
+func newUnexposeChange(params UnexposeParams, requires ...string) *UnexposeChange {
+	return &UnexposeChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "unexpose",
+		},
+		Params: params,
+	}
+}
+
+// UnexposeChange holds a change for unexposing an existing application which
+// is not exposed in the bundle.
+type UnexposeChange struct {
+	changeInfo
+	// Params holds parameters for unexposing an application.
+	Params UnexposeParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *UnexposeChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Application}
+}
+
+// UnexposeParams holds parameters for unexposing an application.
+type UnexposeParams struct {
+	// Application holds the name of the application that must be unexposed.
+	Application string
+}
+
+// newUpgradeCharmChange creates a new change for upgrading the charm used by
+// an existing application.
+func newUpgradeCharmChange(params UpgradeCharmParams, requires ...string) *UpgradeCharmChange {
+	return &UpgradeCharmChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "upgradeCharm",
+		},
+		Params: params,
+	}
+}
+
+// UpgradeCharmChange holds a change for upgrading the charm used by an
+// existing application.
+type UpgradeCharmChange struct {
+	changeInfo
+	// Params holds parameters for upgrading the charm.
+	Params UpgradeCharmParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *UpgradeCharmChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Application, ch.Params.Charm, ch.Params.Series, ch.Params.Channel}
+}
+
+// UpgradeCharmParams holds parameters for upgrading the charm used by an
+// existing application.
+type UpgradeCharmParams struct {
+	// Charm holds the placeholder pointing to the change adding or uploading
+	// the charm to be used by the application.
+	Charm string
+	// Application holds the name of the existing application to be upgraded.
+	Application string
+	// Series holds the series of the charm to be used.
+	Series string
+	// Channel holds the charm store channel the charm is retrieved from, or
+	// an empty string if the default channel must be used.
+	Channel string
+}
+
+// newUpdateResourceChange creates a new change for updating the revision of
+// an application resource.
+func newUpdateResourceChange(params UpdateResourceParams, requires ...string) *UpdateResourceChange {
+	return &UpdateResourceChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "updateResource",
+		},
+		Params: params,
+	}
+}
+
+// UpdateResourceChange holds a change for updating the revision of a resource
+// used by an existing application.
+type UpdateResourceChange struct {
+	changeInfo
+	// Params holds parameters for updating a resource.
+	Params UpdateResourceParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *UpdateResourceChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Application, ch.Params.Resource, ch.Params.Revision}
+}
+
+// UpdateResourceParams holds parameters for updating the revision of an
+// application resource.
+type UpdateResourceParams struct {
+	// Application holds the name of the application.
+	Application string
+	// Resource holds the name of the resource.
+	Resource string
+	// Revision holds the resource revision to be used.
+	Revision int
+}
+
+// newSetBindingsChange creates a new change for setting endpoint bindings.
+func newSetBindingsChange(params SetBindingsParams, requires ...string) *SetBindingsChange {
+	return &SetBindingsChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "setBindings",
+		},
+		Params: params,
+	}
+}
+
+// SetBindingsChange holds a change for binding the endpoints of an existing
+// application to different spaces.
+type SetBindingsChange struct {
+	changeInfo
+	// Params holds parameters for setting endpoint bindings.
+	Params SetBindingsParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *SetBindingsChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Application, ch.Params.EndpointBindings, ch.Params.Force}
+}
+
+// SetBindingsParams holds parameters for setting the endpoint bindings of an
+// existing application.
+type SetBindingsParams struct {
+	// Application holds the name of the application.
+	Application string
+	// EndpointBindings holds the spaces to bind endpoints to, keyed by
+	// endpoint name. Only the bindings which differ from the ones in the
+	// model are included.
+	EndpointBindings map[string]string
+	// Force reports whether endpoints must be rebound even if the
+	// application units are already running.
+	Force bool
+}
+
+// newSetOptionsChange creates a new change for setting application options.
+func newSetOptionsChange(params SetOptionsParams, requires ...string) *SetOptionsChange {
+	return &SetOptionsChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "setConfig",
+		},
+		Params: params,
+	}
+}
+
+// SetOptionsChange holds a change for setting the options of an existing
+// application.
+type SetOptionsChange struct {
+	changeInfo
+	// Params holds parameters for setting options.
+	Params SetOptionsParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *SetOptionsChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Application, ch.Params.Options}
+}
+
+// SetOptionsParams holds parameters for setting the options of an existing
+// application.
+type SetOptionsParams struct {
+	// Application holds the name of the application.
 	Application string
+	// Options holds the options to be set. Only the options whose values
+	// differ from the ones in the model are included.
+	Options map[string]interface{}
+}
+
+// newSetConstraintsChange creates a new change for setting constraints.
+func newSetConstraintsChange(params SetConstraintsParams, requires ...string) *SetConstraintsChange {
+	return &SetConstraintsChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "setConstraints",
+		},
+		Params: params,
+	}
+}
+
+// SetConstraintsChange holds a change for setting the constraints of an
+// existing application or machine.
+type SetConstraintsChange struct {
+	changeInfo
+	// Params holds parameters for setting constraints.
+	Params SetConstraintsParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *SetConstraintsChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Id, string(ch.Params.EntityType), ch.Params.Constraints}
+}
+
+// SetConstraintsParams holds parameters for setting the constraints of an
+// existing application or machine.
+type SetConstraintsParams struct {
+	// Id holds the application name or the machine id.
+	Id string
+	// EntityType holds the type of the entity, "application" or "machine".
+	EntityType EntityType
+	// Constraints holds the constraints to be set.
+	Constraints string
 }
 
 // newSetAnnotationsChange creates a new change for setting annotations.
@@ -350,6 +841,129 @@ func (ch *SetAnnotationsChange) GUIArgs() []interface{} {
 	return []interface{}{ch.Params.Id, string(ch.Params.EntityType), ch.Params.Annotations}
 }
 
+// newRemoveUnitChange creates a new change for removing a unit.
+func newRemoveUnitChange(params RemoveUnitParams, requires ...string) *RemoveUnitChange {
+	return &RemoveUnitChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "removeUnit",
+		},
+		Params: params,
+	}
+}
+
+// RemoveUnitChange holds a change for removing a unit which is present in
+// the model but not declared in the bundle.
+type RemoveUnitChange struct {
+	changeInfo
+	// Params holds parameters for removing a unit.
+	Params RemoveUnitParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *RemoveUnitChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Unit}
+}
+
+// RemoveUnitParams holds parameters for removing a unit.
+type RemoveUnitParams struct {
+	// Unit holds the name of the unit to be removed, for instance "mysql/1".
+	Unit string
+}
+
+// newRemoveRelationChange creates a new change for removing a relation.
+func newRemoveRelationChange(params RemoveRelationParams, requires ...string) *RemoveRelationChange {
+	return &RemoveRelationChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "removeRelation",
+		},
+		Params: params,
+	}
+}
+
+// RemoveRelationChange holds a change for removing a relation which is
+// established in the model but not declared in the bundle.
+type RemoveRelationChange struct {
+	changeInfo
+	// Params holds parameters for removing a relation.
+	Params RemoveRelationParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *RemoveRelationChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Endpoint1, ch.Params.Endpoint2}
+}
+
+// RemoveRelationParams holds parameters for removing a relation.
+type RemoveRelationParams struct {
+	// Endpoint1 and Endpoint2 hold the relation endpoints in the
+	// "application:relation" form, for instance "mysql:db".
+	Endpoint1 string
+	Endpoint2 string
+}
+
+// newDestroyApplicationChange creates a new change for destroying an
+// application.
+func newDestroyApplicationChange(params DestroyApplicationParams, requires ...string) *DestroyApplicationChange {
+	return &DestroyApplicationChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "destroyApplication",
+		},
+		Params: params,
+	}
+}
+
+// DestroyApplicationChange holds a change for destroying an application which
+// is present in the model but not declared in the bundle.
+type DestroyApplicationChange struct {
+	changeInfo
+	// Params holds parameters for destroying an application.
+	Params DestroyApplicationParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *DestroyApplicationChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Application}
+}
+
+// DestroyApplicationParams holds parameters for destroying an application.
+type DestroyApplicationParams struct {
+	// Application holds the name of the application to be destroyed.
+	Application string
+}
+
+// newDestroyMachineChange creates a new change for destroying a machine.
+func newDestroyMachineChange(params DestroyMachineParams, requires ...string) *DestroyMachineChange {
+	return &DestroyMachineChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "destroyMachine",
+		},
+		Params: params,
+	}
+}
+
+// DestroyMachineChange holds a change for destroying a machine or container
+// which is present in the model but not used by the bundle.
+type DestroyMachineChange struct {
+	changeInfo
+	// Params holds parameters for destroying a machine.
+	Params DestroyMachineParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *DestroyMachineChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Machine}
+}
+
+// DestroyMachineParams holds parameters for destroying a machine.
+type DestroyMachineParams struct {
+	// Machine holds the id of the machine or container to be destroyed.
+	Machine string
+}
+
 // EntityType holds entity types ("application" or "machine").
 type EntityType string
 
@@ -361,15 +975,52 @@ const (
 // SetAnnotationsParams holds parameters for setting annotations.
 type SetAnnotationsParams struct {
 	// Id is the placeholder for the application or machine change corresponding to
-	// the entity to be annotated.
+	// the entity to be annotated, or the application name or machine id if the
+	// entity already exists in the model.
 	Id string
 	// EntityType holds the type of the entity, "application" or "machine".
 	EntityType EntityType
-	// Annotations holds the annotations as key/value pairs.
+	// Annotations holds the annotations as key/value pairs. When the entity
+	// already exists in the model, only the annotations to be changed are
+	// included.
 	Annotations map[string]string
 }
 
-// changeset holds the list of changes returned by FromData.
+// newRemoveAnnotationsChange creates a new change for removing annotations.
+func newRemoveAnnotationsChange(params RemoveAnnotationsParams, requires ...string) *RemoveAnnotationsChange {
+	return &RemoveAnnotationsChange{
+		changeInfo: changeInfo{
+			requires: requires,
+			method:   "removeAnnotations",
+		},
+		Params: params,
+	}
+}
+
+// RemoveAnnotationsChange holds a change for removing application and
+// machine annotations which are not declared in the bundle.
+type RemoveAnnotationsChange struct {
+	changeInfo
+	// Params holds parameters for removing annotations.
+	Params RemoveAnnotationsParams
+}
+
+// GUIArgs implements Change.GUIArgs.
+func (ch *RemoveAnnotationsChange) GUIArgs() []interface{} {
+	return []interface{}{ch.Params.Id, string(ch.Params.EntityType), ch.Params.Keys}
+}
+
+// RemoveAnnotationsParams holds parameters for removing annotations.
+type RemoveAnnotationsParams struct {
+	// Id holds the application name or the machine id.
+	Id string
+	// EntityType holds the type of the entity, "application" or "machine".
+	EntityType EntityType
+	// Keys holds the keys of the annotations to be removed.
+	Keys []string
+}
+
+// changeset holds the list of changes returned by FromConfig.
 type changeset struct {
 	changes []Change
 }
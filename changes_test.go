@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -56,7 +57,7 @@ var fromDataTests = []struct {
 		Params: bundlechanges.AddCharmParams{
 			Charm: "django",
 		},
-		GUIArgs: []interface{}{"django", ""},
+		GUIArgs: []interface{}{"django", "", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -73,6 +74,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}},
@@ -106,7 +108,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:precise/mediawiki-10",
 			Series: "precise",
 		},
-		GUIArgs: []interface{}{"cs:precise/mediawiki-10", "precise"},
+		GUIArgs: []interface{}{"cs:precise/mediawiki-10", "precise", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -126,6 +128,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{"data": 3},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -157,7 +160,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:precise/mysql-28",
 			Series: "precise",
 		},
-		GUIArgs: []interface{}{"cs:precise/mysql-28", "precise"},
+		GUIArgs: []interface{}{"cs:precise/mysql-28", "precise", ""},
 	}, {
 		Id:     "deploy-5",
 		Method: "deploy",
@@ -175,6 +178,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-4"},
 	}, {
@@ -220,7 +224,7 @@ var fromDataTests = []struct {
 			Charm:  "precise/mediawiki-10",
 			Series: "precise",
 		},
-		GUIArgs: []interface{}{"precise/mediawiki-10", "precise"},
+		GUIArgs: []interface{}{"precise/mediawiki-10", "precise", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -238,6 +242,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -257,6 +262,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -301,7 +307,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:trusty/django-42",
 			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty"},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -320,6 +326,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -329,7 +336,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:trusty/haproxy-47",
 			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"cs:trusty/haproxy-47", "trusty"},
+		GUIArgs: []interface{}{"cs:trusty/haproxy-47", "trusty", ""},
 	}, {
 		Id:     "deploy-3",
 		Method: "deploy",
@@ -348,6 +355,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-2"},
 	}, {
@@ -389,12 +397,14 @@ var fromDataTests = []struct {
 		Params: bundlechanges.AddMachineParams{
 			ContainerType: "lxc",
 			Series:        "trusty",
+			Constraints:   "cpu-cores=4 cpu-power=42",
 			ParentId:      "$addMachines-6",
 		},
 		GUIArgs: []interface{}{
 			bundlechanges.AddMachineOptions{
 				ContainerType: "lxc",
 				Series:        "trusty",
+				Constraints:   "cpu-cores=4 cpu-power=42",
 				ParentId:      "$addMachines-6",
 			},
 		},
@@ -477,7 +487,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:trusty/django-42",
 			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty"},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -495,6 +505,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -572,7 +583,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:precise/mediawiki-10",
 			Series: "precise",
 		},
-		GUIArgs: []interface{}{"cs:precise/mediawiki-10", "precise"},
+		GUIArgs: []interface{}{"cs:precise/mediawiki-10", "precise", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -590,6 +601,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -599,7 +611,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:precise/mysql-28",
 			Series: "precise",
 		},
-		GUIArgs: []interface{}{"cs:precise/mysql-28", "precise"},
+		GUIArgs: []interface{}{"cs:precise/mysql-28", "precise", ""},
 	}, {
 		Id:     "deploy-3",
 		Method: "deploy",
@@ -618,6 +630,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-2"},
 	}, {
@@ -649,7 +662,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:trusty/django-42",
 			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty"},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -667,6 +680,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -675,7 +689,7 @@ var fromDataTests = []struct {
 		Params: bundlechanges.AddCharmParams{
 			Charm: "wordpress",
 		},
-		GUIArgs: []interface{}{"wordpress", ""},
+		GUIArgs: []interface{}{"wordpress", "", ""},
 	}, {
 		Id:     "deploy-3",
 		Method: "deploy",
@@ -692,6 +706,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-2"},
 	}, {
@@ -754,7 +769,7 @@ var fromDataTests = []struct {
                     - lxc:memcached/2
                     - kvm:ror
             ror:
-                charm: vivid/rails
+                charm: trusty/rails
                 num_units: 2
                 to:
                     - new
@@ -770,7 +785,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:trusty/django-42",
 			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty"},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -788,6 +803,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -797,7 +813,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:trusty/mem-47",
 			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"cs:trusty/mem-47", "trusty"},
+		GUIArgs: []interface{}{"cs:trusty/mem-47", "trusty", ""},
 	}, {
 		Id:     "deploy-3",
 		Method: "deploy",
@@ -815,33 +831,35 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-2"},
 	}, {
 		Id:     "addCharm-4",
 		Method: "addCharm",
 		Params: bundlechanges.AddCharmParams{
-			Charm:  "vivid/rails",
-			Series: "vivid",
+			Charm:  "trusty/rails",
+			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"vivid/rails", "vivid"},
+		GUIArgs: []interface{}{"trusty/rails", "trusty", ""},
 	}, {
 		Id:     "deploy-5",
 		Method: "deploy",
 		Params: bundlechanges.AddApplicationParams{
 			Charm:       "$addCharm-4",
 			Application: "ror",
-			Series:      "vivid",
+			Series:      "trusty",
 		},
 		GUIArgs: []interface{}{
 			"$addCharm-4",
-			"vivid",
+			"trusty",
 			"ror",
 			map[string]interface{}{},
 			"",
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-4"},
 	}, {
@@ -916,11 +934,11 @@ var fromDataTests = []struct {
 		Id:     "addMachines-23",
 		Method: "addMachines",
 		Params: bundlechanges.AddMachineParams{
-			Series: "vivid",
+			Series: "trusty",
 		},
 		GUIArgs: []interface{}{
 			bundlechanges.AddMachineOptions{
-				Series: "vivid",
+				Series: "trusty",
 			},
 		},
 	}, {
@@ -1069,7 +1087,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:trusty/django-42",
 			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty"},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -1087,6 +1105,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -1228,7 +1247,7 @@ var fromDataTests = []struct {
 			Charm:  "cs:trusty/django-42",
 			Series: "trusty",
 		},
-		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty"},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -1253,6 +1272,7 @@ var fromDataTests = []struct {
 			},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -1287,7 +1307,7 @@ var fromDataTests = []struct {
 		Params: bundlechanges.AddCharmParams{
 			Charm: "django",
 		},
-		GUIArgs: []interface{}{"django", ""},
+		GUIArgs: []interface{}{"django", "", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -1305,6 +1325,7 @@ var fromDataTests = []struct {
 			map[string]string{},
 			map[string]string{"foo": "bar"},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}},
@@ -1329,7 +1350,7 @@ machines:
 			Charm:  "cs:precise/juju-gui",
 			Series: "precise",
 		},
-		GUIArgs: []interface{}{"cs:precise/juju-gui", "precise"},
+		GUIArgs: []interface{}{"cs:precise/juju-gui", "precise", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
@@ -1347,6 +1368,7 @@ machines:
 			map[string]string{},
 			map[string]string{},
 			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
 	}, {
@@ -1412,9 +1434,108 @@ machines:
 			"$addMachines-6",
 		},
 	}},
+}, {
+	about: "same charm deployed with different series",
+	content: `
+        services:
+            django-trusty:
+                charm: cs:django-42
+                series: trusty
+            django-xenial:
+                charm: cs:django-42
+                series: xenial
+            django-xenial-2:
+                charm: cs:django-42
+                series: xenial
+    `,
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:django-42",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:django-42", "trusty", ""},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "trusty",
+			Application: "django-trusty",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"django-trusty",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "addCharm-2",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:django-42",
+			Series: "xenial",
+		},
+		GUIArgs: []interface{}{"cs:django-42", "xenial", ""},
+	}, {
+		Id:     "deploy-3",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-2",
+			Series:      "xenial",
+			Application: "django-xenial",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-2",
+			"xenial",
+			"django-xenial",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-2"},
+	}, {
+		Id:     "deploy-4",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-2",
+			Series:      "xenial",
+			Application: "django-xenial-2",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-2",
+			"xenial",
+			"django-xenial-2",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-2"},
+	}},
 }}
 
 func (s *changesSuite) assertParseData(c *gc.C, content string, expected []record) {
+	s.assertParseDataWithModel(c, content, nil, expected)
+}
+
+func (s *changesSuite) assertParseDataWithModel(c *gc.C, content string, model *bundlechanges.Model, expected []record) {
+	s.assertParseDataWithConfig(c, content, bundlechanges.ChangesConfig{Model: model}, expected)
+}
+
+func (s *changesSuite) assertParseDataWithConfig(c *gc.C, content string, config bundlechanges.ChangesConfig, expected []record) {
 	// Retrieve and validate the bundle data.
 	data, err := charm.ReadBundleData(strings.NewReader(content))
 	c.Assert(err, jc.ErrorIsNil)
@@ -1422,7 +1543,9 @@ func (s *changesSuite) assertParseData(c *gc.C, content string, expected []recor
 	c.Assert(err, jc.ErrorIsNil)
 
 	// Retrieve the changes, and convert them to a sequence of records.
-	changes := bundlechanges.FromData(data)
+	config.Bundle = data
+	changes, err := bundlechanges.FromConfig(config)
+	c.Assert(err, jc.ErrorIsNil)
 	records := make([]record, len(changes))
 	for i, change := range changes {
 		r := record{
@@ -1451,67 +1574,2204 @@ func (s *changesSuite) TestFromData(c *gc.C) {
 	}
 }
 
-func (s *changesSuite) assertLocalBundleChanges(c *gc.C, charmDir, bundleContent, series string) {
-	expected := []record{{
+var fromDataWithModelTests = []struct {
+	// about describes the test.
+	about string
+	// content is the YAML encoded bundle content.
+	content string
+	// model holds the existing model contents.
+	model *bundlechanges.Model
+	// expected holds the expected changes required to deploy the bundle.
+	expected []record
+}{{
+	about: "existing application, unit and exposure",
+	content: `
+        services:
+            django:
+                charm: django
+                num_units: 1
+            mysql:
+                charm: cs:trusty/mysql-42
+                num_units: 2
+                expose: true
+        relations:
+            - - django:db
+              - mysql:db
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"mysql": {
+				Charm: "cs:trusty/mysql-42",
+				Units: []bundlechanges.Unit{{Name: "mysql/0", Machine: "0"}},
+			},
+		},
+		Machines: map[string]*bundlechanges.Machine{
+			"0": {},
+		},
+	},
+	expected: []record{{
 		Id:     "addCharm-0",
 		Method: "addCharm",
 		Params: bundlechanges.AddCharmParams{
-			Charm:  charmDir,
-			Series: series,
+			Charm: "django",
 		},
-		GUIArgs: []interface{}{charmDir, series},
+		GUIArgs: []interface{}{"django", "", ""},
 	}, {
 		Id:     "deploy-1",
 		Method: "deploy",
 		Params: bundlechanges.AddApplicationParams{
 			Charm:       "$addCharm-0",
 			Application: "django",
-			Series:      series,
 		},
 		GUIArgs: []interface{}{
 			"$addCharm-0",
-			series,
+			"",
 			"django",
-			map[string]interface{}{}, // options.
-			"",                  // constraints.
-			map[string]string{}, // storage.
-			map[string]string{}, // endpoint bindings.
-			map[string]int{},    // resources.
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
 		},
 		Requires: []string{"addCharm-0"},
-	}}
-	s.assertParseData(c, bundleContent, expected)
-}
-
-func (s *changesSuite) TestLocalCharmWithExplicitSeries(c *gc.C) {
-	charmDir := c.MkDir()
-	bundleContent := fmt.Sprintf(`
+	}, {
+		Id:     "expose-2",
+		Method: "expose",
+		Params: bundlechanges.ExposeParams{
+			Application: "mysql",
+		},
+		GUIArgs: []interface{}{"mysql"},
+	}, {
+		Id:     "addRelation-3",
+		Method: "addRelation",
+		Params: bundlechanges.AddRelationParams{
+			Endpoint1: "$deploy-1:db",
+			Endpoint2: "mysql:db",
+		},
+		GUIArgs:  []interface{}{"$deploy-1:db", "mysql:db"},
+		Requires: []string{"deploy-1"},
+	}, {
+		Id:     "addUnit-4",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", nil},
+		Requires: []string{"deploy-1"},
+	}, {
+		Id:     "addUnit-5",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "mysql",
+		},
+		GUIArgs: []interface{}{"mysql", nil},
+	}},
+}, {
+	about: "nothing to do",
+	content: `
         services:
-            django:
-                charm: %s
-                series: xenial
-    `, charmDir)
-	s.assertLocalBundleChanges(c, charmDir, bundleContent, "xenial")
-}
-
-func (s *changesSuite) TestLocalCharmWithSeriesFromCharm(c *gc.C) {
-	charmDir := c.MkDir()
-	bundleContent := fmt.Sprintf(`
+            mysql:
+                charm: cs:trusty/mysql-42
+                num_units: 1
+                annotations:
+                    gui-x: "10"
+            wordpress:
+                charm: cs:trusty/wordpress-1
+                num_units: 1
+        relations:
+            - - wordpress:db
+              - mysql:db
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"mysql": {
+				Charm:       "cs:trusty/mysql-42",
+				Annotations: map[string]string{"gui-x": "10", "gui-y": "20"},
+				Units:       []bundlechanges.Unit{{Name: "mysql/0", Machine: "0"}},
+			},
+			"wordpress": {
+				Charm: "cs:trusty/wordpress-1",
+				Units: []bundlechanges.Unit{{Name: "wordpress/3", Machine: "1"}},
+			},
+		},
+		Relations: []bundlechanges.Relation{{
+			Endpoint1: "mysql:db",
+			Endpoint2: "wordpress:db",
+		}},
+	},
+	expected: []record{},
+}, {
+	about: "units placed on existing machines",
+	content: `
         services:
-            django:
-                charm: %s
-    `, charmDir)
-	charmMeta := `
-name: multi-series
-summary: "That's a dummy charm with multi-series."
-description: |
-    This is a longer description which
-    potentially contains multiple lines.
-series:
-    - precise
-    - trusty
-`[1:]
-	err := ioutil.WriteFile(filepath.Join(charmDir, "metadata.yaml"), []byte(charmMeta), 0644)
-	c.Assert(err, jc.ErrorIsNil)
-	s.assertLocalBundleChanges(c, charmDir, bundleContent, "precise")
+            mysql:
+                charm: cs:trusty/mysql-42
+                num_units: 2
+                to: ["0"]
+            wordpress:
+                charm: cs:trusty/wordpress-1
+                num_units: 1
+                to: ["lxc:0"]
+        machines:
+            "0":
+                annotations:
+                    foo: bar
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"mysql": {
+				Charm: "cs:trusty/mysql-42",
+				Units: []bundlechanges.Unit{{Name: "mysql/0", Machine: "4"}},
+			},
+		},
+		Machines: map[string]*bundlechanges.Machine{
+			"4": {Annotations: map[string]string{"foo": "baz"}},
+		},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/wordpress-1",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/wordpress-1", "trusty", ""},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "trusty",
+			Application: "wordpress",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"wordpress",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "setAnnotations-2",
+		Method: "setAnnotations",
+		Params: bundlechanges.SetAnnotationsParams{
+			Id:          "4",
+			EntityType:  bundlechanges.MachineType,
+			Annotations: map[string]string{"foo": "bar"},
+		},
+		GUIArgs: []interface{}{"4", "machine", map[string]string{"foo": "bar"}},
+	}, {
+		Id:     "addUnit-3",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "mysql",
+			To:          "4",
+		},
+		GUIArgs: []interface{}{"mysql", "4"},
+	}, {
+		Id:     "addMachines-5",
+		Method: "addMachines",
+		Params: bundlechanges.AddMachineParams{
+			ContainerType: "lxc",
+			ParentId:      "4",
+			Series:        "trusty",
+		},
+		GUIArgs: []interface{}{
+			bundlechanges.AddMachineOptions{
+				ContainerType: "lxc",
+				ParentId:      "4",
+				Series:        "trusty",
+			},
+		},
+	}, {
+		Id:     "addUnit-4",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-5",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-5"},
+		Requires: []string{"deploy-1", "addMachines-5"},
+	}},
+}, {
+	about: "charm upgrade",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-38
+                num_units: 1
+            mysql-slave:
+                charm: cs:trusty/mysql-38
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"mysql": {
+				Charm: "cs:trusty/mysql-28",
+				Units: []bundlechanges.Unit{{Name: "mysql/0", Machine: "0"}},
+			},
+		},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/mysql-38",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/mysql-38", "trusty", ""},
+	}, {
+		Id:     "upgradeCharm-1",
+		Method: "upgradeCharm",
+		Params: bundlechanges.UpgradeCharmParams{
+			Charm:       "$addCharm-0",
+			Application: "mysql",
+			Series:      "trusty",
+		},
+		GUIArgs:  []interface{}{"mysql", "$addCharm-0", "trusty", ""},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "deploy-2",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "trusty",
+			Application: "mysql-slave",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"mysql-slave",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}},
+}, {
+	about: "charm URLs compared semantically",
+	content: `
+        services:
+            haproxy:
+                charm: cs:xenial/haproxy-5
+            mysql:
+                charm: mysql
+            wordpress:
+                charm: trusty/wordpress-1
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"haproxy":   {Charm: "cs:trusty/haproxy-5"},
+			"mysql":     {Charm: "cs:trusty/mysql-28"},
+			"wordpress": {Charm: "cs:trusty/wordpress-1"},
+		},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:xenial/haproxy-5",
+			Series: "xenial",
+		},
+		GUIArgs: []interface{}{"cs:xenial/haproxy-5", "xenial", ""},
+	}, {
+		Id:     "upgradeCharm-1",
+		Method: "upgradeCharm",
+		Params: bundlechanges.UpgradeCharmParams{
+			Charm:       "$addCharm-0",
+			Application: "haproxy",
+			Series:      "xenial",
+		},
+		GUIArgs:  []interface{}{"haproxy", "$addCharm-0", "xenial", ""},
+		Requires: []string{"addCharm-0"},
+	}},
+}, {
+	about: "changed options",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-42
+                options:
+                    flavor: percona
+                    max-connections: 100
+                    debug: true
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"mysql": {
+				Charm: "cs:trusty/mysql-42",
+				Options: map[string]interface{}{
+					"flavor":          "mysql",
+					"max-connections": float64(100),
+					"debug":           true,
+				},
+			},
+		},
+	},
+	expected: []record{{
+		Id:     "setConfig-0",
+		Method: "setConfig",
+		Params: bundlechanges.SetOptionsParams{
+			Application: "mysql",
+			Options:     map[string]interface{}{"flavor": "percona"},
+		},
+		GUIArgs: []interface{}{"mysql", map[string]interface{}{"flavor": "percona"}},
+	}},
+}, {
+	about: "changed options after charm upgrade",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-43
+                options:
+                    flavor: percona
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"mysql": {
+				Charm: "cs:trusty/mysql-42",
+			},
+		},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/mysql-43",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/mysql-43", "trusty", ""},
+	}, {
+		Id:     "upgradeCharm-1",
+		Method: "upgradeCharm",
+		Params: bundlechanges.UpgradeCharmParams{
+			Charm:       "$addCharm-0",
+			Application: "mysql",
+			Series:      "trusty",
+		},
+		GUIArgs:  []interface{}{"mysql", "$addCharm-0", "trusty", ""},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "setConfig-2",
+		Method: "setConfig",
+		Params: bundlechanges.SetOptionsParams{
+			Application: "mysql",
+			Options:     map[string]interface{}{"flavor": "percona"},
+		},
+		GUIArgs:  []interface{}{"mysql", map[string]interface{}{"flavor": "percona"}},
+		Requires: []string{"upgradeCharm-1"},
+	}},
+}, {
+	about: "changed constraints",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-42
+                constraints: mem=4G cores=2
+                num_units: 1
+                to: ["0"]
+            wordpress:
+                charm: cs:trusty/wordpress-1
+                constraints: mem=2G
+        machines:
+            "0":
+                constraints: root-disk=10G
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"mysql": {
+				Charm:       "cs:trusty/mysql-42",
+				Constraints: "cores=2 mem=4096M",
+				Units:       []bundlechanges.Unit{{Name: "mysql/0", Machine: "1"}},
+			},
+			"wordpress": {
+				Charm:       "cs:trusty/wordpress-1",
+				Constraints: "mem=1G",
+			},
+		},
+		Machines: map[string]*bundlechanges.Machine{
+			"1": {Constraints: "root-disk=8192"},
+		},
+	},
+	expected: []record{{
+		Id:     "setConstraints-0",
+		Method: "setConstraints",
+		Params: bundlechanges.SetConstraintsParams{
+			Id:          "wordpress",
+			EntityType:  bundlechanges.ApplicationType,
+			Constraints: "mem=2G",
+		},
+		GUIArgs: []interface{}{"wordpress", "application", "mem=2G"},
+	}, {
+		Id:     "setConstraints-1",
+		Method: "setConstraints",
+		Params: bundlechanges.SetConstraintsParams{
+			Id:          "1",
+			EntityType:  bundlechanges.MachineType,
+			Constraints: "root-disk=10G",
+		},
+		GUIArgs: []interface{}{"1", "machine", "root-disk=10G"},
+	}},
+}, {
+	about: "relations compared after endpoint normalization",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-42
+            wordpress:
+                charm: cs:trusty/wordpress-1
+            haproxy:
+                charm: cs:trusty/haproxy-5
+        relations:
+            - - wordpress
+              - mysql
+            - - haproxy:reverseproxy
+              - wordpress
+            - - haproxy:juju-info
+              - wordpress:juju-info
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"haproxy":   {Charm: "cs:trusty/haproxy-5"},
+			"mysql":     {Charm: "cs:trusty/mysql-42"},
+			"wordpress": {Charm: "cs:trusty/wordpress-1"},
+		},
+		Relations: []bundlechanges.Relation{{
+			Endpoint1: "mysql:db",
+			Endpoint2: "wordpress:db",
+		}, {
+			Endpoint1: "wordpress:website",
+			Endpoint2: "haproxy:reverseproxy",
+		}},
+	},
+	expected: []record{{
+		Id:     "addRelation-0",
+		Method: "addRelation",
+		Params: bundlechanges.AddRelationParams{
+			Endpoint1: "haproxy:juju-info",
+			Endpoint2: "wordpress:juju-info",
+		},
+		GUIArgs: []interface{}{"haproxy:juju-info", "wordpress:juju-info"},
+	}},
+}, {
+	about: "exposure convergence",
+	content: `
+        services:
+            haproxy:
+                charm: cs:trusty/haproxy-5
+                expose: true
+            mysql:
+                charm: cs:trusty/mysql-42
+            wordpress:
+                charm: cs:trusty/wordpress-1
+                expose: true
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"haproxy": {
+				Charm: "cs:trusty/haproxy-5",
+			},
+			"mysql": {
+				Charm:   "cs:trusty/mysql-42",
+				Exposed: true,
+			},
+			"wordpress": {
+				Charm:   "cs:trusty/wordpress-1",
+				Exposed: true,
+			},
+		},
+	},
+	expected: []record{{
+		Id:     "expose-0",
+		Method: "expose",
+		Params: bundlechanges.ExposeParams{
+			Application: "haproxy",
+		},
+		GUIArgs: []interface{}{"haproxy"},
+	}, {
+		Id:     "unexpose-1",
+		Method: "unexpose",
+		Params: bundlechanges.UnexposeParams{
+			Application: "mysql",
+		},
+		GUIArgs: []interface{}{"mysql"},
+	}},
+}, {
+	about: "resource updates after charm upgrade",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-43
+                resources:
+                    backup: 2
+                    data: 3
+                    logs: 1
+    `,
+	model: &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"mysql": {
+				Charm:     "cs:trusty/mysql-42",
+				Resources: map[string]int{"data": 2, "logs": 1},
+			},
+		},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/mysql-43",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/mysql-43", "trusty", ""},
+	}, {
+		Id:     "upgradeCharm-1",
+		Method: "upgradeCharm",
+		Params: bundlechanges.UpgradeCharmParams{
+			Charm:       "$addCharm-0",
+			Application: "mysql",
+			Series:      "trusty",
+		},
+		GUIArgs:  []interface{}{"mysql", "$addCharm-0", "trusty", ""},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "updateResource-2",
+		Method: "updateResource",
+		Params: bundlechanges.UpdateResourceParams{
+			Application: "mysql",
+			Resource:    "backup",
+			Revision:    2,
+		},
+		GUIArgs:  []interface{}{"mysql", "backup", 2},
+		Requires: []string{"upgradeCharm-1"},
+	}, {
+		Id:     "updateResource-3",
+		Method: "updateResource",
+		Params: bundlechanges.UpdateResourceParams{
+			Application: "mysql",
+			Resource:    "data",
+			Revision:    3,
+		},
+		GUIArgs:  []interface{}{"mysql", "data", 3},
+		Requires: []string{"upgradeCharm-1"},
+	}},
+}}
+
+func (s *changesSuite) TestFromDataWithModel(c *gc.C) {
+	for i, test := range fromDataWithModelTests {
+		c.Logf("\ntest %d: %s", i, test.about)
+		s.assertParseDataWithModel(c, test.content, test.model, test.expected)
+	}
+}
+
+var fromConfigTests = []struct {
+	// about describes the test.
+	about string
+	// content is the YAML encoded bundle content.
+	content string
+	// config holds the configuration used to generate the changes. The
+	// bundle data is retrieved from the content above.
+	config bundlechanges.ChangesConfig
+	// expected holds the expected changes required to deploy the bundle.
+	expected []record
+}{{
+	about: "prune entities not declared in the bundle",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-42
+                num_units: 1
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"mysql": {
+					Charm: "cs:trusty/mysql-42",
+					Units: []bundlechanges.Unit{
+						{Name: "mysql/1", Machine: "1"},
+						{Name: "mysql/0", Machine: "0"},
+					},
+				},
+				"wordpress": {
+					Charm: "cs:trusty/wordpress-1",
+					Units: []bundlechanges.Unit{
+						{Name: "wordpress/0", Machine: "2/lxd/0"},
+					},
+				},
+			},
+			Machines: map[string]*bundlechanges.Machine{
+				"0":       {},
+				"1":       {},
+				"2":       {},
+				"2/lxd/0": {},
+			},
+			Relations: []bundlechanges.Relation{{
+				Endpoint1: "mysql:db",
+				Endpoint2: "wordpress:db",
+			}},
+		},
+		Prune: true,
+	},
+	expected: []record{{
+		Id:     "removeRelation-0",
+		Method: "removeRelation",
+		Params: bundlechanges.RemoveRelationParams{
+			Endpoint1: "mysql:db",
+			Endpoint2: "wordpress:db",
+		},
+		GUIArgs: []interface{}{"mysql:db", "wordpress:db"},
+	}, {
+		Id:     "removeUnit-1",
+		Method: "removeUnit",
+		Params: bundlechanges.RemoveUnitParams{
+			Unit: "mysql/1",
+		},
+		GUIArgs: []interface{}{"mysql/1"},
+	}, {
+		Id:     "destroyApplication-2",
+		Method: "destroyApplication",
+		Params: bundlechanges.DestroyApplicationParams{
+			Application: "wordpress",
+		},
+		GUIArgs:  []interface{}{"wordpress"},
+		Requires: []string{"removeRelation-0"},
+	}, {
+		Id:     "destroyMachine-3",
+		Method: "destroyMachine",
+		Params: bundlechanges.DestroyMachineParams{
+			Machine: "2/lxd/0",
+		},
+		GUIArgs:  []interface{}{"2/lxd/0"},
+		Requires: []string{"destroyApplication-2"},
+	}, {
+		Id:     "destroyMachine-4",
+		Method: "destroyMachine",
+		Params: bundlechanges.DestroyMachineParams{
+			Machine: "2",
+		},
+		GUIArgs:  []interface{}{"2"},
+		Requires: []string{"destroyApplication-2", "destroyMachine-3"},
+	}, {
+		Id:     "destroyMachine-5",
+		Method: "destroyMachine",
+		Params: bundlechanges.DestroyMachineParams{
+			Machine: "1",
+		},
+		GUIArgs:  []interface{}{"1"},
+		Requires: []string{"removeUnit-1"},
+	}},
+}, {
+	about: "no pruning by default",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-42
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"mysql": {
+					Charm: "cs:trusty/mysql-42",
+					Units: []bundlechanges.Unit{{Name: "mysql/0", Machine: "0"}},
+				},
+				"wordpress": {
+					Charm: "cs:trusty/wordpress-1",
+				},
+			},
+			Machines: map[string]*bundlechanges.Machine{
+				"0": {},
+			},
+		},
+	},
+	expected: []record{},
+}, {
+	about: "pruning keeps units of subordinate applications",
+	content: `
+        services:
+            django:
+                charm: cs:trusty/django-42
+                num_units: 1
+            nrpe:
+                charm: cs:trusty/nrpe-3
+        relations:
+            - ["django:juju-info", "nrpe:general-info"]
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"django": {
+					Charm: "cs:trusty/django-42",
+					Units: []bundlechanges.Unit{
+						{Name: "django/0", Machine: "0"},
+						{Name: "django/1", Machine: "1"},
+					},
+				},
+				"nrpe": {
+					Charm:       "cs:trusty/nrpe-3",
+					Subordinate: true,
+					Units: []bundlechanges.Unit{
+						{Name: "nrpe/0", Machine: "0"},
+						{Name: "nrpe/1", Machine: "1"},
+					},
+				},
+			},
+			Machines: map[string]*bundlechanges.Machine{
+				"0": {},
+				"1": {},
+			},
+			Relations: []bundlechanges.Relation{{
+				Endpoint1: "django:juju-info",
+				Endpoint2: "nrpe:general-info",
+			}},
+		},
+		Prune: true,
+	},
+	expected: []record{{
+		Id:     "removeUnit-0",
+		Method: "removeUnit",
+		Params: bundlechanges.RemoveUnitParams{
+			Unit: "django/1",
+		},
+		GUIArgs: []interface{}{"django/1"},
+	}, {
+		Id:     "destroyMachine-1",
+		Method: "destroyMachine",
+		Params: bundlechanges.DestroyMachineParams{
+			Machine: "1",
+		},
+		GUIArgs:  []interface{}{"1"},
+		Requires: []string{"removeUnit-0"},
+	}},
+}, {
+	about: "bundle machines mapped to model machines",
+	content: `
+        services:
+            django:
+                charm: cs:trusty/django-42
+                num_units: 3
+                to: ["0", "1", "lxd:1"]
+        machines:
+            "0": {}
+            "1": {}
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Machines: map[string]*bundlechanges.Machine{
+				"0": {Constraints: "mem=8G"},
+				"4": {Constraints: "cores=4"},
+			},
+		},
+		UseExistingMachines: true,
+		MachineMap:          map[string]string{"1": "4"},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/django-42",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "trusty",
+			Application: "django",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"django",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "addUnit-2",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "0",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "0"},
+		Requires: []string{"deploy-1"},
+	}, {
+		Id:     "addUnit-3",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "4",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "4"},
+		Requires: []string{"deploy-1"},
+	}, {
+		Id:     "addMachines-5",
+		Method: "addMachines",
+		Params: bundlechanges.AddMachineParams{
+			ContainerType: "lxd",
+			ParentId:      "4",
+			Series:        "trusty",
+		},
+		GUIArgs: []interface{}{
+			bundlechanges.AddMachineOptions{
+				ContainerType: "lxd",
+				ParentId:      "4",
+				Series:        "trusty",
+			},
+		},
+	}, {
+		Id:     "addUnit-4",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-5",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-5"},
+		Requires: []string{"deploy-1", "addMachines-5"},
+	}},
+}, {
+	about: "incremental annotations",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-42
+                annotations:
+                    gui-x: "10"
+                    gui-y: "20"
+            wordpress:
+                charm: cs:trusty/wordpress-1
+                annotations:
+                    gui-x: "30"
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"mysql": {
+					Charm:       "cs:trusty/mysql-42",
+					Annotations: map[string]string{"gui-x": "10", "gui-y": "42", "owner": "who"},
+				},
+				"wordpress": {
+					Charm:       "cs:trusty/wordpress-1",
+					Annotations: map[string]string{"gui-x": "30"},
+				},
+			},
+		},
+		Prune: true,
+	},
+	expected: []record{{
+		Id:     "setAnnotations-0",
+		Method: "setAnnotations",
+		Params: bundlechanges.SetAnnotationsParams{
+			Id:          "mysql",
+			EntityType:  bundlechanges.ApplicationType,
+			Annotations: map[string]string{"gui-y": "20"},
+		},
+		GUIArgs: []interface{}{"mysql", "application", map[string]string{"gui-y": "20"}},
+	}, {
+		Id:     "removeAnnotations-1",
+		Method: "removeAnnotations",
+		Params: bundlechanges.RemoveAnnotationsParams{
+			Id:         "mysql",
+			EntityType: bundlechanges.ApplicationType,
+			Keys:       []string{"owner"},
+		},
+		GUIArgs: []interface{}{"mysql", "application", []string{"owner"}},
+	}},
+}, {
+	about: "changed endpoint bindings",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-42
+                bindings:
+                    "": alpha
+                    db: beta
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"mysql": {
+					Charm:            "cs:trusty/mysql-42",
+					EndpointBindings: map[string]string{"": "alpha", "db": "alpha"},
+				},
+			},
+		},
+		ForceBindings: true,
+	},
+	expected: []record{{
+		Id:     "setBindings-0",
+		Method: "setBindings",
+		Params: bundlechanges.SetBindingsParams{
+			Application:      "mysql",
+			EndpointBindings: map[string]string{"db": "beta"},
+			Force:            true,
+		},
+		GUIArgs: []interface{}{"mysql", map[string]string{"db": "beta"}, true},
+	}},
+}, {
+	about: "series retrieved from the charm resolver",
+	content: `
+        services:
+            django:
+                charm: django
+    `,
+	config: bundlechanges.ChangesConfig{
+		CharmResolver: bundlechanges.MemoryCharmResolver{
+			"django": {Series: "xenial"},
+		},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "django",
+			Series: "xenial",
+		},
+		GUIArgs: []interface{}{"django", "xenial", ""},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "xenial",
+			Application: "django",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"xenial",
+			"django",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}},
+}, {
+	about: "charm channels",
+	content: `
+        services:
+            django-edge:
+                charm: cs:trusty/django-42
+            django-stable:
+                charm: cs:trusty/django-42
+            django-other:
+                charm: cs:trusty/django-42
+    `,
+	config: bundlechanges.ChangesConfig{
+		Channel:             "stable",
+		ApplicationChannels: map[string]string{"django-edge": "edge"},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:   "cs:trusty/django-42",
+			Series:  "trusty",
+			Channel: "edge",
+		},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", "edge"},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Channel:     "edge",
+			Series:      "trusty",
+			Application: "django-edge",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"django-edge",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"edge",
+		},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "addCharm-2",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:   "cs:trusty/django-42",
+			Series:  "trusty",
+			Channel: "stable",
+		},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", "stable"},
+	}, {
+		Id:     "deploy-3",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-2",
+			Channel:     "stable",
+			Series:      "trusty",
+			Application: "django-other",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-2",
+			"trusty",
+			"django-other",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"stable",
+		},
+		Requires: []string{"addCharm-2"},
+	}, {
+		Id:     "deploy-4",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-2",
+			Channel:     "stable",
+			Series:      "trusty",
+			Application: "django-stable",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-2",
+			"trusty",
+			"django-stable",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"stable",
+		},
+		Requires: []string{"addCharm-2"},
+	}},
+}, {
+	about: "charm upgrade from a channel",
+	content: `
+        services:
+            mysql:
+                charm: cs:trusty/mysql-38
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"mysql": {
+					Charm: "cs:trusty/mysql-28",
+				},
+			},
+		},
+		ApplicationChannels: map[string]string{"mysql": "candidate"},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:   "cs:trusty/mysql-38",
+			Series:  "trusty",
+			Channel: "candidate",
+		},
+		GUIArgs: []interface{}{"cs:trusty/mysql-38", "trusty", "candidate"},
+	}, {
+		Id:     "upgradeCharm-1",
+		Method: "upgradeCharm",
+		Params: bundlechanges.UpgradeCharmParams{
+			Charm:       "$addCharm-0",
+			Application: "mysql",
+			Series:      "trusty",
+			Channel:     "candidate",
+		},
+		GUIArgs:  []interface{}{"mysql", "$addCharm-0", "trusty", "candidate"},
+		Requires: []string{"addCharm-0"},
+	}},
+}, {
+	about: "trusted applications",
+	content: `
+        services:
+            django:
+                charm: cs:trusty/django-42
+            haproxy:
+                charm: cs:trusty/haproxy-5
+            mysql:
+                charm: cs:trusty/mysql-42
+            wordpress:
+                charm: cs:trusty/wordpress-1
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"haproxy": {
+					Charm:   "cs:trusty/haproxy-5",
+					Trusted: true,
+				},
+				"mysql": {
+					Charm: "cs:trusty/mysql-42",
+				},
+				"wordpress": {
+					Charm:   "cs:trusty/wordpress-1",
+					Trusted: true,
+				},
+			},
+		},
+		TrustedApplications: map[string]bool{
+			"django":  true,
+			"haproxy": false,
+			"mysql":   true,
+		},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/django-42",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "trusty",
+			Application: "django",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"django",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "trust-2",
+		Method: "trust",
+		Params: bundlechanges.TrustParams{
+			Application: "$deploy-1",
+		},
+		GUIArgs:  []interface{}{"$deploy-1"},
+		Requires: []string{"deploy-1"},
+	}, {
+		Id:     "untrust-3",
+		Method: "untrust",
+		Params: bundlechanges.UntrustParams{
+			Application: "haproxy",
+		},
+		GUIArgs: []interface{}{"haproxy"},
+	}, {
+		Id:     "trust-4",
+		Method: "trust",
+		Params: bundlechanges.TrustParams{
+			Application: "mysql",
+		},
+		GUIArgs: []interface{}{"mysql"},
+	}},
+}, {
+	about: "application constraints applied to new machines and containers",
+	content: `
+        services:
+            django:
+                charm: cs:trusty/django-42
+                num_units: 2
+                constraints: mem=4G cores=2 spaces=dmz
+                to:
+                    - new
+                    - lxd:new
+    `,
+	config: bundlechanges.ChangesConfig{
+		PlacementConstraints: []string{"mem", "cores"},
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/django-42",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "trusty",
+			Application: "django",
+			Constraints: "mem=4G cores=2 spaces=dmz",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"django",
+			map[string]interface{}{},
+			"mem=4G cores=2 spaces=dmz",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "addMachines-4",
+		Method: "addMachines",
+		Params: bundlechanges.AddMachineParams{
+			Series:      "trusty",
+			Constraints: "mem=4G cores=2",
+		},
+		GUIArgs: []interface{}{
+			bundlechanges.AddMachineOptions{
+				Series:      "trusty",
+				Constraints: "mem=4G cores=2",
+			},
+		},
+	}, {
+		Id:     "addMachines-5",
+		Method: "addMachines",
+		Params: bundlechanges.AddMachineParams{
+			ContainerType: "lxd",
+			Series:        "trusty",
+			Constraints:   "mem=4G cores=2",
+		},
+		GUIArgs: []interface{}{
+			bundlechanges.AddMachineOptions{
+				ContainerType: "lxd",
+				Series:        "trusty",
+				Constraints:   "mem=4G cores=2",
+			},
+		},
+	}, {
+		Id:     "addUnit-2",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-4",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-4"},
+		Requires: []string{"deploy-1", "addMachines-4"},
+	}, {
+		Id:     "addUnit-3",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-5",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-5"},
+		Requires: []string{"deploy-1", "addMachines-5"},
+	}},
+}, {
+	about: "spread unplaced units",
+	content: `
+        services:
+            django:
+                charm: cs:trusty/django-42
+                num_units: 2
+    `,
+	config: bundlechanges.ChangesConfig{
+		PlacementPolicy: bundlechanges.SpreadPlacement,
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/django-42",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "trusty",
+			Application: "django",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"django",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "addMachines-4",
+		Method: "addMachines",
+		Params: bundlechanges.AddMachineParams{
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{
+			bundlechanges.AddMachineOptions{Series: "trusty"},
+		},
+	}, {
+		Id:     "addMachines-5",
+		Method: "addMachines",
+		Params: bundlechanges.AddMachineParams{
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{
+			bundlechanges.AddMachineOptions{Series: "trusty"},
+		},
+	}, {
+		Id:     "addUnit-2",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-4",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-4"},
+		Requires: []string{"deploy-1", "addMachines-4"},
+	}, {
+		Id:     "addUnit-3",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-5",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-5"},
+		Requires: []string{"deploy-1", "addMachines-5"},
+	}},
+}, {
+	about: "pack unplaced units",
+	content: `
+        services:
+            django:
+                charm: cs:trusty/django-42
+                num_units: 3
+            mysql:
+                charm: cs:trusty/mysql-42
+                num_units: 1
+                to: ["0"]
+        machines:
+            "0":
+    `,
+	config: bundlechanges.ChangesConfig{
+		PlacementPolicy: bundlechanges.PackPlacement,
+		UnitsPerMachine: 2,
+	},
+	expected: []record{{
+		Id:     "addCharm-0",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/django-42",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/django-42", "trusty", ""},
+	}, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-0",
+			Series:      "trusty",
+			Application: "django",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-0",
+			"trusty",
+			"django",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-0"},
+	}, {
+		Id:     "addCharm-2",
+		Method: "addCharm",
+		Params: bundlechanges.AddCharmParams{
+			Charm:  "cs:trusty/mysql-42",
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{"cs:trusty/mysql-42", "trusty", ""},
+	}, {
+		Id:     "deploy-3",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$addCharm-2",
+			Series:      "trusty",
+			Application: "mysql",
+		},
+		GUIArgs: []interface{}{
+			"$addCharm-2",
+			"trusty",
+			"mysql",
+			map[string]interface{}{},
+			"",
+			map[string]string{},
+			map[string]string{},
+			map[string]int{},
+			"",
+		},
+		Requires: []string{"addCharm-2"},
+	}, {
+		Id:      "addMachines-4",
+		Method:  "addMachines",
+		Params:  bundlechanges.AddMachineParams{},
+		GUIArgs: []interface{}{bundlechanges.AddMachineOptions{}},
+	}, {
+		Id:     "addUnit-5",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-4",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-4"},
+		Requires: []string{"deploy-1", "addMachines-4"},
+	}, {
+		Id:     "addUnit-8",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-3",
+			To:          "$addMachines-4",
+		},
+		GUIArgs:  []interface{}{"$deploy-3", "$addMachines-4"},
+		Requires: []string{"deploy-3", "addMachines-4"},
+	}, {
+		Id:     "addMachines-9",
+		Method: "addMachines",
+		Params: bundlechanges.AddMachineParams{
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{
+			bundlechanges.AddMachineOptions{Series: "trusty"},
+		},
+	}, {
+		Id:     "addUnit-6",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-9",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-9"},
+		Requires: []string{"deploy-1", "addMachines-9"},
+	}, {
+		Id:     "addUnit-7",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "$deploy-1",
+			To:          "$addMachines-9",
+		},
+		GUIArgs:  []interface{}{"$deploy-1", "$addMachines-9"},
+		Requires: []string{"deploy-1", "addMachines-9"},
+	}},
+}, {
+	about: "pack unplaced units onto mapped machines",
+	content: `
+        services:
+            django:
+                charm: cs:trusty/django-42
+                num_units: 3
+            mysql:
+                charm: cs:trusty/mysql-42
+                num_units: 2
+                to: ["2", "10"]
+        machines:
+            "2": {}
+            "10": {}
+    `,
+	config: bundlechanges.ChangesConfig{
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"django": {
+					Charm: "cs:trusty/django-42",
+					Units: []bundlechanges.Unit{{Name: "django/0", Machine: "5"}},
+				},
+				"mysql": {
+					Charm: "cs:trusty/mysql-42",
+					Units: []bundlechanges.Unit{
+						{Name: "mysql/0", Machine: "5"},
+						{Name: "mysql/1", Machine: "6"},
+					},
+				},
+			},
+			Machines: map[string]*bundlechanges.Machine{
+				"5": {},
+				"6": {},
+			},
+		},
+		MachineMap:      map[string]string{"2": "5", "10": "6"},
+		PlacementPolicy: bundlechanges.PackPlacement,
+		UnitsPerMachine: 2,
+	},
+	expected: []record{{
+		Id:     "addUnit-0",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "django",
+			To:          "6",
+		},
+		GUIArgs: []interface{}{"django", "6"},
+	}, {
+		Id:     "addMachines-2",
+		Method: "addMachines",
+		Params: bundlechanges.AddMachineParams{
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{
+			bundlechanges.AddMachineOptions{Series: "trusty"},
+		},
+	}, {
+		Id:     "addUnit-1",
+		Method: "addUnit",
+		Params: bundlechanges.AddUnitParams{
+			Application: "django",
+			To:          "$addMachines-2",
+		},
+		GUIArgs:  []interface{}{"django", "$addMachines-2"},
+		Requires: []string{"addMachines-2"},
+	}},
+}}
+
+func (s *changesSuite) TestFromConfig(c *gc.C) {
+	for i, test := range fromConfigTests {
+		c.Logf("\ntest %d: %s", i, test.about)
+		s.assertParseDataWithConfig(c, test.content, test.config, test.expected)
+	}
+}
+
+var machineMapErrorsTests = []struct {
+	// about describes the test.
+	about string
+	// useExisting reports whether bundle machines are mapped to the model
+	// machines with the same ids.
+	useExisting bool
+	// machineMap holds the explicit machine mappings.
+	machineMap map[string]string
+	// expectedError holds the expected error message.
+	expectedError string
+}{{
+	about:         "model machine not found",
+	machineMap:    map[string]string{"1": "42"},
+	expectedError: `invalid machine mapping "1=42": machine "42" not found in the model`,
+}, {
+	about:         "bundle machine not declared",
+	machineMap:    map[string]string{"42": "4"},
+	expectedError: `invalid machine mapping "42=4": machine "42" not declared in the bundle`,
+}, {
+	about:         "bundle machines mapped to the same model machine",
+	machineMap:    map[string]string{"1": "4", "2": "4"},
+	expectedError: `invalid machine mapping "2=4": machine "4" is already mapped to bundle machine "1"`,
+}, {
+	about:         "bundle machine mapped to a model machine used by another",
+	useExisting:   true,
+	machineMap:    map[string]string{"1": "0"},
+	expectedError: `invalid machine mapping "1=0": machine "0" is already mapped to bundle machine "0"`,
+}}
+
+func (s *changesSuite) TestInvalidMachineMap(c *gc.C) {
+	data := &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:trusty/django-42", NumUnits: 3, To: []string{"0", "1", "2"}},
+		},
+		Machines: map[string]*charm.MachineSpec{
+			"0": {},
+			"1": {},
+			"2": {},
+		},
+	}
+	model := &bundlechanges.Model{
+		Machines: map[string]*bundlechanges.Machine{
+			"0": {},
+			"4": {},
+		},
+	}
+	for i, test := range machineMapErrorsTests {
+		c.Logf("\ntest %d: %s", i, test.about)
+		changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+			Bundle:              data,
+			Model:               model,
+			UseExistingMachines: test.useExisting,
+			MachineMap:          test.machineMap,
+		})
+		c.Check(err, gc.ErrorMatches, test.expectedError)
+		c.Check(changes, gc.IsNil)
+	}
+}
+
+var fromDataErrorsTests = []struct {
+	// about describes the test.
+	about string
+	// data holds the bundle data, which is not verified.
+	data *charm.BundleData
+	// expectedError holds the expected error message.
+	expectedError string
+	// expectedType holds a value with the same type as the expected error.
+	expectedType interface{}
+}{{
+	about: "invalid charm URL",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "bad:wolf"},
+		},
+	},
+	expectedError: `invalid charm "bad:wolf" for application "django": .*`,
+	expectedType:  &bundlechanges.CharmError{},
+}, {
+	about: "invalid placement directive",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:trusty/django-42", NumUnits: 1, To: []string{"bad:wolf:42"}},
+		},
+	},
+	expectedError: `invalid placement "bad:wolf:42" for application "django": .*`,
+	expectedType:  &bundlechanges.PlacementError{},
+}, {
+	about: "placement to a unit beyond num_units",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:trusty/django-42", NumUnits: 1, To: []string{"mysql/2"}},
+			"mysql":  {Charm: "cs:trusty/mysql-42", NumUnits: 1},
+		},
+	},
+	expectedError: `invalid placement "mysql/2" for application "django": unit "mysql/2" not declared in the bundle`,
+	expectedType:  &bundlechanges.PlacementError{},
+}, {
+	about: "placement cycle",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django":    {Charm: "cs:trusty/django-42", NumUnits: 1, To: []string{"memcached/0"}},
+			"memcached": {Charm: "cs:trusty/memcached-1", NumUnits: 1, To: []string{"django/0"}},
+		},
+	},
+	expectedError: `invalid placement "memcached/0" for application "django": placement cycle: django/0 -> memcached/0 -> django/0`,
+	expectedType:  &bundlechanges.PlacementError{},
+}, {
+	about: "placement cycle through containers",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django":    {Charm: "cs:trusty/django-42", NumUnits: 1, To: []string{"lxd:mysql/0"}},
+			"memcached": {Charm: "cs:trusty/memcached-1", NumUnits: 1, To: []string{"django/0"}},
+			"mysql":     {Charm: "cs:trusty/mysql-42", NumUnits: 1, To: []string{"memcached"}},
+		},
+	},
+	expectedError: `invalid placement "lxd:mysql/0" for application "django": placement cycle: django/0 -> mysql/0 -> memcached/0 -> django/0`,
+	expectedType:  &bundlechanges.PlacementError{},
+}, {
+	about: "placement to an undeclared machine",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:trusty/django-42", NumUnits: 1, To: []string{"lxc:42"}},
+		},
+	},
+	expectedError: `invalid placement "lxc:42" for application "django": machine "42" not declared in the bundle`,
+	expectedType:  &bundlechanges.PlacementError{},
+}, {
+	about: "placement to a machine with a different series",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:xenial/django-42", NumUnits: 2, To: []string{"lxd:1", "1"}},
+		},
+		Machines: map[string]*charm.MachineSpec{
+			"1": {Series: "trusty"},
+		},
+	},
+	expectedError: `series "xenial" of application "django" does not match series "trusty" of placement "1"`,
+	expectedType:  &bundlechanges.SeriesErrors{},
+}, {
+	about: "placement to a machine with a different default series",
+	data: &charm.BundleData{
+		Series: "trusty",
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:django-42", Series: "xenial", NumUnits: 1, To: []string{"1"}},
+		},
+		Machines: map[string]*charm.MachineSpec{
+			"1": nil,
+		},
+	},
+	expectedError: `series "xenial" of application "django" does not match series "trusty" of placement "1"`,
+	expectedType:  &bundlechanges.SeriesErrors{},
+}, {
+	about: "co-location with a unit with a different series",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django":    {Charm: "cs:xenial/django-42", NumUnits: 1, To: []string{"memcached/0"}},
+			"memcached": {Charm: "cs:trusty/memcached-1", NumUnits: 1},
+		},
+	},
+	expectedError: `series "xenial" of application "django" does not match series "trusty" of placement "memcached/0"`,
+	expectedType:  &bundlechanges.SeriesErrors{},
+}, {
+	about: "multiple series mismatches",
+	data: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django":    {Charm: "cs:xenial/django-42", NumUnits: 3, To: []string{"1", "1", "memcached/0"}},
+			"memcached": {Charm: "cs:trusty/memcached-1", NumUnits: 1, To: []string{"2"}},
+		},
+		Machines: map[string]*charm.MachineSpec{
+			"1": {Series: "trusty"},
+			"2": {Series: "xenial"},
+		},
+	},
+	expectedError: `series "xenial" of application "django" does not match series "trusty" of placement "1"; ` +
+		`series "xenial" of application "django" does not match series "trusty" of placement "memcached/0"; ` +
+		`series "trusty" of application "memcached" does not match series "xenial" of placement "2"`,
+	expectedType: &bundlechanges.SeriesErrors{},
+}}
+
+func (s *changesSuite) TestFromDataErrors(c *gc.C) {
+	for i, test := range fromDataErrorsTests {
+		c.Logf("\ntest %d: %s", i, test.about)
+		changes, err := bundlechanges.FromData(test.data)
+		c.Check(err, gc.ErrorMatches, test.expectedError)
+		c.Check(err, gc.FitsTypeOf, test.expectedType)
+		c.Check(changes, gc.IsNil)
+	}
+}
+
+func (s *changesSuite) TestSeriesOfExistingApplication(c *gc.C) {
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle: &charm.BundleData{
+			Series: "xenial",
+			Applications: map[string]*charm.ApplicationSpec{
+				"django":    {Charm: "cs:django-42", NumUnits: 1, To: []string{"memcached/0"}},
+				"memcached": {Charm: "cs:memcached-1", NumUnits: 1},
+			},
+		},
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"memcached": {
+					Charm:  "cs:trusty/memcached-1",
+					Series: "trusty",
+					Units:  []bundlechanges.Unit{{Name: "memcached/0", Machine: "0"}},
+				},
+			},
+			Machines: map[string]*bundlechanges.Machine{
+				"0": {Series: "trusty"},
+			},
+		},
+	})
+	c.Check(err, gc.ErrorMatches, `series "xenial" of application "django" does not match series "trusty" of placement "memcached/0"`)
+	c.Check(err, gc.FitsTypeOf, &bundlechanges.SeriesErrors{})
+	c.Check(changes, gc.IsNil)
+}
+
+func (s *changesSuite) assertLocalBundleChanges(c *gc.C, charmChange record, bundleContent, series string) {
+	charmId := charmChange.Id
+	expected := []record{charmChange, {
+		Id:     "deploy-1",
+		Method: "deploy",
+		Params: bundlechanges.AddApplicationParams{
+			Charm:       "$" + charmId,
+			Application: "django",
+			Series:      series,
+		},
+		GUIArgs: []interface{}{
+			"$" + charmId,
+			series,
+			"django",
+			map[string]interface{}{}, // options.
+			"",                  // constraints.
+			map[string]string{}, // storage.
+			map[string]string{}, // endpoint bindings.
+			map[string]int{},    // resources.
+			"",                  // channel.
+		},
+		Requires: []string{charmId},
+	}}
+	s.assertParseData(c, bundleContent, expected)
+}
+
+func (s *changesSuite) TestLocalCharmWithExplicitSeries(c *gc.C) {
+	charmDir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(charmDir, "metadata.yaml"), []byte(charmMeta), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	bundleContent := fmt.Sprintf(`
+        services:
+            django:
+                charm: %s
+                series: trusty
+    `, charmDir)
+	info, err := bundlechanges.FilesystemCharmResolver{}.ResolveCharm(charmDir)
+	c.Assert(err, jc.ErrorIsNil)
+	s.assertLocalBundleChanges(c, record{
+		Id:     "uploadCharm-0",
+		Method: "uploadCharm",
+		Params: bundlechanges.UploadCharmParams{
+			Path:   charmDir,
+			SHA256: info.ArchiveSHA256,
+			Series: "trusty",
+		},
+		GUIArgs: []interface{}{charmDir, info.ArchiveSHA256, "trusty"},
+	}, bundleContent, "trusty")
+}
+
+func (s *changesSuite) TestInvalidLocalCharm(c *gc.C) {
+	// The directory does not contain a valid charm.
+	charmDir := c.MkDir()
+	changes, err := bundlechanges.FromData(&charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: charmDir, Series: "xenial"},
+		},
+	})
+	c.Check(err, gc.ErrorMatches, `invalid charm ".*" for application "django": .*`)
+	c.Check(err, gc.FitsTypeOf, &bundlechanges.CharmError{})
+	c.Check(changes, gc.IsNil)
+}
+
+func (s *changesSuite) TestLocalCharmWithSeriesFromCharm(c *gc.C) {
+	charmDir := c.MkDir()
+	bundleContent := fmt.Sprintf(`
+        services:
+            django:
+                charm: %s
+    `, charmDir)
+	charmMeta := `
+name: multi-series
+summary: "That's a dummy charm with multi-series."
+description: |
+    This is a longer description which
+    potentially contains multiple lines.
+series:
+    - precise
+    - trusty
+`[1:]
+	err := ioutil.WriteFile(filepath.Join(charmDir, "metadata.yaml"), []byte(charmMeta), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	info, err := bundlechanges.FilesystemCharmResolver{}.ResolveCharm(charmDir)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info.ArchiveSHA256, gc.Matches, "[0-9a-f]{64}")
+	s.assertLocalBundleChanges(c, record{
+		Id:     "uploadCharm-0",
+		Method: "uploadCharm",
+		Params: bundlechanges.UploadCharmParams{
+			Path:   charmDir,
+			SHA256: info.ArchiveSHA256,
+			Series: "precise",
+		},
+		GUIArgs: []interface{}{charmDir, info.ArchiveSHA256, "precise"},
+	}, bundleContent, "precise")
+}
+
+func (s *changesSuite) TestLocalCharmRelativeToBundleDir(c *gc.C) {
+	bundleDir := c.MkDir()
+	charmDir := filepath.Join(bundleDir, "charms", "django")
+	err := os.MkdirAll(charmDir, 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	err = ioutil.WriteFile(filepath.Join(charmDir, "metadata.yaml"), []byte(charmMeta), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	data := &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "./charms/django", Series: "trusty"},
+		},
+	}
+	err = data.VerifyLocal(bundleDir, nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle:    data,
+		BundleDir: bundleDir,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(changes, gc.HasLen, 2)
+	params := changes[0].(*bundlechanges.UploadCharmChange).Params
+	c.Assert(params.Path, gc.Equals, charmDir)
+	c.Assert(params.Series, gc.Equals, "trusty")
+}
+
+func (s *changesSuite) TestLocalCharmNotUpgraded(c *gc.C) {
+	charmDir := c.MkDir()
+	charmMeta := `
+name: django
+summary: "That's a dummy charm."
+description: "A dummy charm."
+series:
+    - xenial
+`[1:]
+	err := ioutil.WriteFile(filepath.Join(charmDir, "metadata.yaml"), []byte(charmMeta), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	bundleContent := fmt.Sprintf(`
+        services:
+            django:
+                charm: %s
+    `, charmDir)
+	// The local charm is compared with the deployed one by name, as its
+	// revision changes every time it is uploaded.
+	s.assertParseDataWithModel(c, bundleContent, &bundlechanges.Model{
+		Applications: map[string]*bundlechanges.Application{
+			"django": {Charm: "local:xenial/django-3"},
+		},
+	}, []record{})
+}
+
+func (s *changesSuite) TestIncludedFiles(c *gc.C) {
+	bundleDir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(bundleDir, "cert.pem"), []byte("certificate"), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	err = ioutil.WriteFile(filepath.Join(bundleDir, "logo.png"), []byte("\x89PNG"), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle: &charm.BundleData{
+			Applications: map[string]*charm.ApplicationSpec{
+				"django": {
+					Charm: "cs:trusty/django-42",
+					Options: map[string]interface{}{
+						"cert":  "include-file://cert.pem",
+						"debug": true,
+					},
+					Annotations: map[string]string{
+						"gui-x": "10",
+						"logo":  "include-base64://" + filepath.Join(bundleDir, "logo.png"),
+					},
+				},
+			},
+		},
+		BundleDir: bundleDir,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(changes, gc.HasLen, 3)
+	c.Assert(changes[1].(*bundlechanges.AddApplicationChange).Params.Options, jc.DeepEquals, map[string]interface{}{
+		"cert":  "certificate",
+		"debug": true,
+	})
+	c.Assert(changes[2].(*bundlechanges.SetAnnotationsChange).Params.Annotations, jc.DeepEquals, map[string]string{
+		"gui-x": "10",
+		"logo":  "iVBORw==",
+	})
+}
+
+func (s *changesSuite) TestIncludedFileNotFound(c *gc.C) {
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle: &charm.BundleData{
+			Applications: map[string]*charm.ApplicationSpec{
+				"django": {
+					Charm:   "cs:trusty/django-42",
+					Options: map[string]interface{}{"cert": "include-file://missing.pem"},
+				},
+			},
+		},
+		BundleDir: c.MkDir(),
+	})
+	c.Check(err, gc.ErrorMatches, `cannot include file in option "cert" for application "django": open .*missing.pem: no such file or directory`)
+	c.Check(err, gc.FitsTypeOf, &bundlechanges.IncludeError{})
+	c.Check(changes, gc.IsNil)
+}
+
+// subordinateResolver holds information about a principal and a subordinate
+// charm, used to test subordinate applications.
+var subordinateResolver = bundlechanges.MemoryCharmResolver{
+	"cs:trusty/django-42": {
+		Meta: &charm.Meta{
+			Name: "django",
+			Provides: map[string]charm.Relation{
+				"website": {Name: "website", Role: charm.RoleProvider, Interface: "http"},
+			},
+		},
+	},
+	"cs:trusty/nrpe-1": {
+		Meta: &charm.Meta{
+			Name:        "nrpe",
+			Subordinate: true,
+			Provides: map[string]charm.Relation{
+				"monitors": {Name: "monitors", Role: charm.RoleProvider, Interface: "monitors"},
+			},
+			Requires: map[string]charm.Relation{
+				"general-info": {Name: "general-info", Role: charm.RoleRequirer, Interface: "juju-info", Scope: charm.ScopeContainer},
+			},
+		},
+	},
+}
+
+var subordinateErrorsTests = []struct {
+	about         string
+	nrpe          *charm.ApplicationSpec
+	relations     [][]string
+	expectedError string
+}{{
+	about:         "units",
+	nrpe:          &charm.ApplicationSpec{Charm: "cs:trusty/nrpe-1", NumUnits: 1},
+	relations:     [][]string{{"nrpe", "django"}},
+	expectedError: `invalid subordinate application "nrpe": num_units must not be specified for subordinate applications`,
+}, {
+	about:         "placement directives",
+	nrpe:          &charm.ApplicationSpec{Charm: "cs:trusty/nrpe-1", To: []string{"django/0"}},
+	relations:     [][]string{{"nrpe", "django"}},
+	expectedError: `invalid subordinate application "nrpe": placement directives must not be specified for subordinate applications`,
+}, {
+	about:         "no relations",
+	nrpe:          &charm.ApplicationSpec{Charm: "cs:trusty/nrpe-1"},
+	expectedError: `invalid subordinate application "nrpe": no container-scoped relation declared in the bundle`,
+}, {
+	about:         "no container-scoped relation",
+	nrpe:          &charm.ApplicationSpec{Charm: "cs:trusty/nrpe-1"},
+	relations:     [][]string{{"nrpe:monitors", "django"}},
+	expectedError: `invalid subordinate application "nrpe": no container-scoped relation declared in the bundle`,
+}}
+
+func (s *changesSuite) TestSubordinateErrors(c *gc.C) {
+	for i, test := range subordinateErrorsTests {
+		c.Logf("\ntest %d: %s", i, test.about)
+		changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+			Bundle: &charm.BundleData{
+				Applications: map[string]*charm.ApplicationSpec{
+					"django": {Charm: "cs:trusty/django-42", NumUnits: 1},
+					"nrpe":   test.nrpe,
+				},
+				Relations: test.relations,
+			},
+			CharmResolver: subordinateResolver,
+		})
+		c.Check(err, gc.ErrorMatches, test.expectedError)
+		c.Check(err, gc.FitsTypeOf, &bundlechanges.SubordinateError{})
+		c.Check(changes, gc.IsNil)
+	}
+}
+
+func (s *changesSuite) TestSubordinateUnitsNotPruned(c *gc.C) {
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle: &charm.BundleData{
+			Applications: map[string]*charm.ApplicationSpec{
+				"django": {Charm: "cs:trusty/django-42", NumUnits: 1},
+				"nrpe":   {Charm: "cs:trusty/nrpe-1"},
+			},
+			Relations: [][]string{{"nrpe:general-info", "django"}},
+		},
+		Model: &bundlechanges.Model{
+			Applications: map[string]*bundlechanges.Application{
+				"django": {
+					Charm: "cs:trusty/django-42",
+					Units: []bundlechanges.Unit{{Name: "django/0", Machine: "0"}},
+				},
+				"nrpe": {
+					Charm: "cs:trusty/nrpe-1",
+					Units: []bundlechanges.Unit{{Name: "nrpe/0", Machine: "0"}},
+				},
+			},
+			Machines: map[string]*bundlechanges.Machine{
+				"0": {},
+			},
+			Relations: []bundlechanges.Relation{{
+				Endpoint1: "nrpe:general-info",
+				Endpoint2: "django:juju-info",
+			}},
+		},
+		CharmResolver: subordinateResolver,
+		Prune:         true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(changes, gc.HasLen, 0)
+}
+
+func (s *changesSuite) TestAvailabilityZones(c *gc.C) {
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle: &charm.BundleData{
+			Applications: map[string]*charm.ApplicationSpec{
+				"django": {
+					Charm:    "cs:trusty/django-42",
+					NumUnits: 3,
+					To:       []string{"zone=us-east-1c", "new"},
+				},
+			},
+			Machines: map[string]*charm.MachineSpec{
+				"0": nil,
+				"1": nil,
+			},
+		},
+		Zones: []string{"us-east-1a", "us-east-1b"},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	var params []bundlechanges.AddMachineParams
+	for _, change := range changes {
+		if change, ok := change.(*bundlechanges.AddMachineChange); ok {
+			params = append(params, change.Params)
+		}
+	}
+	c.Assert(params, jc.DeepEquals, []bundlechanges.AddMachineParams{
+		{Zone: "us-east-1a"},
+		{Zone: "us-east-1b"},
+		{Series: "trusty", Zone: "us-east-1c"},
+		{Series: "trusty", Zone: "us-east-1a"},
+		{Series: "trusty", Zone: "us-east-1b"},
+	})
+	c.Assert(changes[4].GUIArgs(), jc.DeepEquals, []interface{}{
+		bundlechanges.AddMachineOptions{Series: "trusty", Zone: "us-east-1c"},
+	})
+}
+
+func (s *changesSuite) TestAvailabilityZonesVerified(c *gc.C) {
+	content := `
+        services:
+            django:
+                charm: cs:trusty/django-42
+                num_units: 4
+                to: ["zone=us-east-1c", "new", "0", "1"]
+        machines:
+            "0": {}
+            "1":
+                zone: us-east-1b
+    `
+	data, err := charm.ReadBundleData(strings.NewReader(content))
+	c.Assert(err, jc.ErrorIsNil)
+	err = bundlechanges.VerifyBundleData(data, "", nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	extras, err := bundlechanges.ReadBundleExtras(strings.NewReader(content))
+	c.Assert(err, jc.ErrorIsNil)
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle:       data,
+		Zones:        []string{"us-east-1a"},
+		MachineZones: extras.Zones,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	var params []bundlechanges.AddMachineParams
+	for _, change := range changes {
+		if change, ok := change.(*bundlechanges.AddMachineChange); ok {
+			params = append(params, change.Params)
+		}
+	}
+	c.Assert(params, jc.DeepEquals, []bundlechanges.AddMachineParams{
+		{Zone: "us-east-1a"},
+		{Zone: "us-east-1b"},
+		{Series: "trusty", Zone: "us-east-1c"},
+		{Series: "trusty", Zone: "us-east-1a"},
+	})
+	// The bundle data is not modified by the verification.
+	c.Assert(data.Applications["django"].To, jc.DeepEquals, []string{"zone=us-east-1c", "new", "0", "1"})
+}
+
+func (s *changesSuite) TestVerifyBundleDataZoneNotSpecified(c *gc.C) {
+	data := &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:trusty/django-42", NumUnits: 1, To: []string{"zone="}},
+		},
+	}
+	err := bundlechanges.VerifyBundleData(data, "", nil, nil)
+	c.Assert(err, gc.FitsTypeOf, &charm.VerificationError{})
+}
+
+func (s *changesSuite) TestAvailabilityZoneNotSpecified(c *gc.C) {
+	changes, err := bundlechanges.FromData(&charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:trusty/django-42", NumUnits: 1, To: []string{"zone="}},
+		},
+	})
+	c.Check(err, gc.ErrorMatches, `invalid placement "zone=" for application "django": availability zone not specified`)
+	c.Check(changes, gc.IsNil)
+}
+
+func (s *changesSuite) TestInvalidPlacementPolicy(c *gc.C) {
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle:          &charm.BundleData{},
+		PlacementPolicy: "bad-wolf",
+	})
+	c.Check(err, gc.ErrorMatches, `invalid placement policy "bad-wolf"`)
+	c.Check(changes, gc.IsNil)
 }
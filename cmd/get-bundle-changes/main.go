@@ -4,18 +4,29 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"gopkg.in/juju/charm.v6-unstable"
 
 	"github.com/juju/bundlechanges"
 )
 
+var (
+	bundleDir = flag.String("bundle-dir", "", "directory local charm paths are relative to (defaults to the bundle directory)")
+	channel   = flag.String("channel", "", "charm store channel charms are retrieved from (like stable, candidate or edge)")
+	overlays  overlayPaths
+)
+
 func main() {
+	flag.Var(&overlays, "overlay", "path to an overlay bundle merged onto the bundle (can be repeated)")
 	flag.Usage = usage
 	flag.Parse()
 	if len(flag.Args()) > 1 {
@@ -23,6 +34,7 @@ func main() {
 		os.Exit(2)
 	}
 	r := os.Stdin
+	dir := *bundleDir
 	if path := flag.Arg(0); path != "" {
 		var err error
 		if r, err = os.Open(path); err != nil {
@@ -30,14 +42,23 @@ func main() {
 			os.Exit(2)
 		}
 		defer r.Close()
+		if dir == "" {
+			dir = filepath.Dir(path)
+		}
 	}
-	if err := process(r, os.Stdout); err != nil {
-		if verr, ok := err.(*charm.VerificationError); ok {
+	if err := process(r, os.Stdout, dir, *channel, overlays...); err != nil {
+		switch err := err.(type) {
+		case *charm.VerificationError:
 			fmt.Fprintf(os.Stderr, "the given bundle is not valid:\n")
-			for _, err := range verr.Errors {
+			for _, err := range err.Errors {
 				fmt.Fprintf(os.Stderr, "%s\n", err)
 			}
-		} else {
+		case *bundlechanges.SeriesErrors:
+			fmt.Fprintf(os.Stderr, "the given bundle is not valid:\n")
+			for _, err := range err.Errors {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+		default:
 			fmt.Fprintf(os.Stderr, "unable to parse bundle: %s\n", err)
 		}
 		os.Exit(1)
@@ -46,26 +67,69 @@ func main() {
 
 // usage outputs instructions on how to use this command.
 func usage() {
-	fmt.Fprintln(os.Stderr, "usage: get-bundle-changes [bundle]")
+	fmt.Fprintln(os.Stderr, "usage: get-bundle-changes [flags] [bundle]")
 	fmt.Fprintln(os.Stderr, "bundle can also be provided on stdin")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
+// overlayPaths holds the paths of overlay bundles provided using the
+// repeatable overlay flag.
+type overlayPaths []string
+
+// String implements flag.Value.
+func (p *overlayPaths) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set implements flag.Value.
+func (p *overlayPaths) Set(path string) error {
+	*p = append(*p, path)
+	return nil
+}
+
 // process generates and print to w the set of changes required to deploy
-// the bundle data to be retrieved using r.
-func process(r io.Reader, w io.Writer) error {
-	// Read the bundle data.
-	data, err := charm.ReadBundleData(r)
+// the bundle data to be retrieved using r, once the overlay bundles at the
+// given paths are merged onto it. Local charm paths are relative to the given
+// bundle directory, and charms are retrieved from the given charm store
+// channel, unless a different channel is declared for the application in the
+// bundle or in an overlay.
+func process(r io.Reader, w io.Writer, bundleDir, channel string, overlayPaths ...string) error {
+	// Read the bundle data, including the fields not supported by the charm
+	// package, like application channels and trust or machine zones.
+	data, extras, err := readBundle(r)
 	if err != nil {
 		return err
 	}
-	// Validate the bundle.
-	if err := data.Verify(nil, nil); err != nil {
+	// Merge the overlays.
+	if len(overlayPaths) != 0 {
+		overlays := make([]*bundlechanges.BundleOverlay, len(overlayPaths))
+		for i, path := range overlayPaths {
+			var overlayExtras *bundlechanges.BundleExtras
+			if overlays[i], overlayExtras, err = readOverlayFile(path); err != nil {
+				return fmt.Errorf("cannot read overlay %q: %s", path, err)
+			}
+			extras.Merge(overlayExtras)
+		}
+		data = bundlechanges.MergeBundleOverlays(data, overlays...)
+	}
+	// Validate the bundle. Local charm paths are relative to the bundle
+	// directory.
+	if err := bundlechanges.VerifyBundleData(data, bundleDir, nil, nil); err != nil {
 		return err
 	}
 	// Generate the changes and convert them to the standard form.
-	changes := bundlechanges.FromData(data)
+	changes, err := bundlechanges.FromConfig(bundlechanges.ChangesConfig{
+		Bundle:              data,
+		BundleDir:           bundleDir,
+		Channel:             channel,
+		ApplicationChannels: extras.Channels,
+		TrustedApplications: extras.Trust,
+		MachineZones:        extras.Zones,
+	})
+	if err != nil {
+		return err
+	}
 	records := make([]*record, len(changes))
 	for i, change := range changes {
 		records[i] = &record{
@@ -84,6 +148,41 @@ func process(r io.Reader, w io.Writer) error {
 	return nil
 }
 
+// readOverlayFile reads the overlay bundle and extras stored in the file at
+// the given path.
+func readOverlayFile(path string) (*bundlechanges.BundleOverlay, *bundlechanges.BundleExtras, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	overlay, err := bundlechanges.ReadBundleOverlay(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	extras, err := bundlechanges.ReadBundleExtras(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	return overlay, extras, nil
+}
+
+// readBundle reads the bundle data and extras from the given reader.
+func readBundle(r io.Reader) (*charm.BundleData, *bundlechanges.BundleExtras, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := charm.ReadBundleData(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	extras, err := bundlechanges.ReadBundleExtras(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, extras, nil
+}
+
 // record holds the JSON representation of a change.
 type record struct {
 	// Id is the unique identifier for this change.
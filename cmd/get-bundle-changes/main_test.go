@@ -0,0 +1,159 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type mainSuite struct{}
+
+var _ = gc.Suite(&mainSuite{})
+
+const charmMeta = `
+name: django
+summary: "That's a dummy charm."
+description: A dummy charm.
+series:
+    - xenial
+`
+
+func (s *mainSuite) TestProcessLocalCharmRelativeToBundleDir(c *gc.C) {
+	bundleDir := c.MkDir()
+	charmDir := filepath.Join(bundleDir, "charms", "django")
+	err := os.MkdirAll(charmDir, 0755)
+	c.Assert(err, jc.ErrorIsNil)
+	err = ioutil.WriteFile(filepath.Join(charmDir, "metadata.yaml"), []byte(charmMeta), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	bundle := `
+services:
+    django:
+        charm: ./charms/django
+`
+	err = ioutil.WriteFile(filepath.Join(bundleDir, "bundle.yaml"), []byte(bundle), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// Run the command from another directory.
+	cwd, err := os.Getwd()
+	c.Assert(err, jc.ErrorIsNil)
+	defer os.Chdir(cwd)
+	err = os.Chdir(c.MkDir())
+	c.Assert(err, jc.ErrorIsNil)
+
+	r, err := os.Open(filepath.Join(bundleDir, "bundle.yaml"))
+	c.Assert(err, jc.ErrorIsNil)
+	defer r.Close()
+	var w bytes.Buffer
+	err = process(r, &w, bundleDir, "")
+	c.Assert(err, jc.ErrorIsNil)
+
+	var records []record
+	err = json.Unmarshal(w.Bytes(), &records)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(records, gc.HasLen, 2)
+	c.Assert(records[0].Method, gc.Equals, "uploadCharm")
+	c.Assert(records[0].Args[0], gc.Equals, charmDir)
+	c.Assert(records[0].Args[2], gc.Equals, "xenial")
+}
+
+func (s *mainSuite) TestProcessChannels(c *gc.C) {
+	overlay := filepath.Join(c.MkDir(), "overlay.yaml")
+	err := ioutil.WriteFile(overlay, []byte(`
+services:
+    mysql:
+        channel: candidate
+`), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	bundle := `
+services:
+    django:
+        charm: cs:trusty/django-42
+        channel: edge
+    mysql:
+        charm: cs:trusty/mysql-42
+`
+	var w bytes.Buffer
+	err = process(strings.NewReader(bundle), &w, "", "stable", overlay)
+	c.Assert(err, jc.ErrorIsNil)
+
+	var records []record
+	err = json.Unmarshal(w.Bytes(), &records)
+	c.Assert(err, jc.ErrorIsNil)
+	channels := make(map[string]interface{})
+	for _, r := range records {
+		if r.Method == "deploy" {
+			channels[r.Args[2].(string)] = r.Args[8]
+		}
+	}
+	c.Assert(channels, jc.DeepEquals, map[string]interface{}{
+		"django": "edge",
+		"mysql":  "candidate",
+	})
+}
+
+func (s *mainSuite) TestProcessTrust(c *gc.C) {
+	bundle := `
+services:
+    django:
+        charm: cs:trusty/django-42
+        trust: true
+    mysql:
+        charm: cs:trusty/mysql-42
+        trust: false
+`
+	var w bytes.Buffer
+	err := process(strings.NewReader(bundle), &w, "", "")
+	c.Assert(err, jc.ErrorIsNil)
+
+	var records []record
+	err = json.Unmarshal(w.Bytes(), &records)
+	c.Assert(err, jc.ErrorIsNil)
+	var trusted []interface{}
+	for _, r := range records {
+		if r.Method == "trust" {
+			trusted = append(trusted, r.Args[0])
+		}
+	}
+	c.Assert(trusted, jc.DeepEquals, []interface{}{"$deploy-1"})
+}
+
+func (s *mainSuite) TestProcessZones(c *gc.C) {
+	bundle := `
+services:
+    django:
+        charm: cs:trusty/django-42
+        num_units: 2
+        to: ["zone=us-east-1a", "0"]
+machines:
+    0:
+        zone: us-east-1b
+`
+	var w bytes.Buffer
+	err := process(strings.NewReader(bundle), &w, "", "")
+	c.Assert(err, jc.ErrorIsNil)
+
+	var records []record
+	err = json.Unmarshal(w.Bytes(), &records)
+	c.Assert(err, jc.ErrorIsNil)
+	var zones []interface{}
+	for _, r := range records {
+		if r.Method == "addMachines" {
+			zones = append(zones, r.Args[0].(map[string]interface{})["zone"])
+		}
+	}
+	c.Assert(zones, jc.DeepEquals, []interface{}{"us-east-1b", "us-east-1a"})
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sizeConstraints holds the constraints whose values are sizes in megabytes,
+// optionally expressed with a unit suffix.
+var sizeConstraints = map[string]bool{
+	"mem":       true,
+	"root-disk": true,
+}
+
+// listConstraints holds the constraints whose values are comma separated
+// lists in which the order is not relevant.
+var listConstraints = map[string]bool{
+	"tags":   true,
+	"spaces": true,
+	"zones":  true,
+}
+
+// sizeMultipliers maps size suffixes to the corresponding number of
+// megabytes.
+var sizeMultipliers = map[string]float64{
+	"M": 1,
+	"G": 1024,
+	"T": 1024 * 1024,
+	"P": 1024 * 1024 * 1024,
+}
+
+// constraintsEqual reports whether the two given constraints strings are
+// semantically equivalent, so that "mem=4G cores=2" is equal to
+// "cores=2 mem=4096M".
+func constraintsEqual(c1, c2 string) bool {
+	m1, m2 := parseConstraints(c1), parseConstraints(c2)
+	if len(m1) != len(m2) {
+		return false
+	}
+	for key, value := range m1 {
+		if v, ok := m2[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// parseConstraints returns the given constraints string as a map of
+// normalized values, keyed by constraint name. Constraints with empty values
+// are ignored.
+func parseConstraints(cons string) map[string]string {
+	m := make(map[string]string)
+	for _, field := range strings.Fields(cons) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+		m[parts[0]] = normalizeConstraint(parts[0], parts[1])
+	}
+	return m
+}
+
+// normalizeConstraint returns the normalized form of the given constraint
+// value, so that equivalent values can be compared as strings.
+func normalizeConstraint(key, value string) string {
+	if sizeConstraints[key] {
+		if size, ok := parseSize(value); ok {
+			return strconv.FormatFloat(size, 'f', -1, 64)
+		}
+		return value
+	}
+	if listConstraints[key] {
+		items := strings.Split(value, ",")
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	}
+	return value
+}
+
+// parseSize returns the given size value in megabytes. Values without a
+// suffix are already expressed in megabytes.
+func parseSize(value string) (float64, bool) {
+	multiplier := 1.0
+	if n := len(value); n > 0 {
+		if m, ok := sizeMultipliers[strings.ToUpper(value[n-1:])]; ok {
+			multiplier = m
+			value = value[:n-1]
+		}
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size * multiplier, true
+}
+
+// selectConstraints returns the given constraints string restricted to the
+// constraints with the given names, preserving their order. All the
+// constraints are returned if no names are given.
+func selectConstraints(cons string, names []string) string {
+	if len(names) == 0 {
+		return cons
+	}
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+	var fields []string
+	for _, field := range strings.Fields(cons) {
+		parts := strings.SplitN(field, "=", 2)
+		if selected[parts[0]] {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, " ")
+}
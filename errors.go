@@ -0,0 +1,110 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CharmError holds an error occurred while processing the charm of a bundle
+// application.
+type CharmError struct {
+	// Application holds the name of the application.
+	Application string
+	// Charm holds the charm URL or path as declared in the bundle.
+	Charm string
+	// Err holds the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *CharmError) Error() string {
+	return fmt.Sprintf("invalid charm %q for application %q: %s", e.Charm, e.Application, e.Err)
+}
+
+// PlacementError holds an error occurred while processing a unit placement
+// directive of a bundle application.
+type PlacementError struct {
+	// Application holds the name of the application whose units are placed.
+	Application string
+	// Directive holds the placement directive at fault, like "lxd:1".
+	Directive string
+	// Err holds the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *PlacementError) Error() string {
+	return fmt.Sprintf("invalid placement %q for application %q: %s", e.Directive, e.Application, e.Err)
+}
+
+// IncludeError holds an error occurred while resolving a file included in an
+// option or annotation value of a bundle application, using the
+// "include-file://" or "include-base64://" prefixes.
+type IncludeError struct {
+	// Application holds the name of the application.
+	Application string
+	// Kind holds the kind of the value including the file, either "option"
+	// or "annotation".
+	Kind string
+	// Key holds the name of the option or annotation.
+	Key string
+	// Err holds the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *IncludeError) Error() string {
+	return fmt.Sprintf("cannot include file in %s %q for application %q: %s", e.Kind, e.Key, e.Application, e.Err)
+}
+
+// SubordinateError holds an error occurred while validating a bundle
+// application whose charm is subordinate.
+type SubordinateError struct {
+	// Application holds the name of the subordinate application.
+	Application string
+	// Err holds the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *SubordinateError) Error() string {
+	return fmt.Sprintf("invalid subordinate application %q: %s", e.Application, e.Err)
+}
+
+// SeriesError holds a series mismatch between a bundle application and the
+// machine or container one of its units is placed to.
+type SeriesError struct {
+	// Application holds the name of the application whose units are placed.
+	Application string
+	// Series holds the series of the application.
+	Series string
+	// Directive holds the placement directive at fault, like "1".
+	Directive string
+	// TargetSeries holds the series of the placement target.
+	TargetSeries string
+}
+
+// Error implements error.
+func (e *SeriesError) Error() string {
+	return fmt.Sprintf("series %q of application %q does not match series %q of placement %q", e.Series, e.Application, e.TargetSeries, e.Directive)
+}
+
+// SeriesErrors holds all the series mismatches found between bundle
+// applications and the machines or containers their units are placed to.
+type SeriesErrors struct {
+	// Errors holds the series mismatches, sorted by application name and
+	// then by placement directive order.
+	Errors []*SeriesError
+}
+
+// Error implements error.
+func (e *SeriesErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"io"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// BundleExtras holds the application and machine fields declared in a bundle
+// which are not included in charm.BundleData.
+type BundleExtras struct {
+	// Channels holds the charm store channels declared using the "channel"
+	// application field, keyed by application name.
+	Channels map[string]string
+	// Trust holds the values of the "trust" application field, keyed by
+	// application name. Applications not declaring the field are not
+	// included.
+	Trust map[string]bool
+	// Zones holds the availability zones declared using the "zone" machine
+	// field, keyed by bundle machine id.
+	Zones map[string]string
+}
+
+// ReadBundleExtras reads the YAML encoded bundle from the given reader, and
+// returns the application and machine fields not included in
+// charm.BundleData. The resulting values are suitable for being used in
+// ChangesConfig.
+func ReadBundleExtras(r io.Reader) (*BundleExtras, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var bundle struct {
+		Applications map[string]*struct {
+			Channel string `yaml:"channel"`
+			Trust   *bool  `yaml:"trust"`
+		} `yaml:"services"`
+		Machines map[string]*struct {
+			Zone string `yaml:"zone"`
+		} `yaml:"machines"`
+	}
+	if err := yaml.Unmarshal(content, &bundle); err != nil {
+		return nil, err
+	}
+	extras := &BundleExtras{
+		Channels: make(map[string]string),
+		Trust:    make(map[string]bool),
+		Zones:    make(map[string]string),
+	}
+	for name, application := range bundle.Applications {
+		if application == nil {
+			continue
+		}
+		if application.Channel != "" {
+			extras.Channels[name] = application.Channel
+		}
+		if application.Trust != nil {
+			extras.Trust[name] = *application.Trust
+		}
+	}
+	for id, machine := range bundle.Machines {
+		if machine != nil && machine.Zone != "" {
+			extras.Zones[id] = machine.Zone
+		}
+	}
+	return extras, nil
+}
+
+// Merge merges the given overlay extras onto these extras, which are modified
+// in place. Values declared in the overlay replace the existing ones.
+func (e *BundleExtras) Merge(overlay *BundleExtras) {
+	for name, channel := range overlay.Channels {
+		if e.Channels == nil {
+			e.Channels = make(map[string]string)
+		}
+		e.Channels[name] = channel
+	}
+	for name, trust := range overlay.Trust {
+		if e.Trust == nil {
+			e.Trust = make(map[string]bool)
+		}
+		e.Trust[name] = trust
+	}
+	for id, zone := range overlay.Zones {
+		if e.Zones == nil {
+			e.Zones = make(map[string]string)
+		}
+		e.Zones[id] = zone
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges_test
+
+import (
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/bundlechanges"
+)
+
+type extrasSuite struct{}
+
+var _ = gc.Suite(&extrasSuite{})
+
+func (s *extrasSuite) TestReadBundleExtras(c *gc.C) {
+	extras, err := bundlechanges.ReadBundleExtras(strings.NewReader(`
+services:
+    django:
+        charm: cs:trusty/django-42
+        channel: edge
+        trust: true
+    haproxy:
+        charm: cs:trusty/haproxy-5
+        trust: false
+    mysql:
+        charm: cs:trusty/mysql-42
+machines:
+    0:
+        zone: us-east-1a
+    1:
+        series: trusty
+`))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(extras, jc.DeepEquals, &bundlechanges.BundleExtras{
+		Channels: map[string]string{"django": "edge"},
+		Trust:    map[string]bool{"django": true, "haproxy": false},
+		Zones:    map[string]string{"0": "us-east-1a"},
+	})
+}
+
+func (s *extrasSuite) TestReadBundleExtrasInvalidYAML(c *gc.C) {
+	extras, err := bundlechanges.ReadBundleExtras(strings.NewReader("services: [bad: wolf"))
+	c.Assert(err, gc.NotNil)
+	c.Assert(extras, gc.IsNil)
+}
+
+func (s *extrasSuite) TestMerge(c *gc.C) {
+	extras := &bundlechanges.BundleExtras{
+		Channels: map[string]string{"django": "edge", "mysql": "stable"},
+		Trust:    map[string]bool{"django": true},
+	}
+	extras.Merge(&bundlechanges.BundleExtras{
+		Channels: map[string]string{"django": "candidate", "haproxy": "beta"},
+		Trust:    map[string]bool{"django": false},
+	})
+	c.Assert(extras, jc.DeepEquals, &bundlechanges.BundleExtras{
+		Channels: map[string]string{"django": "candidate", "mysql": "stable", "haproxy": "beta"},
+		Trust:    map[string]bool{"django": false},
+	})
+}
@@ -4,18 +4,77 @@
 package bundlechanges
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 
 	"gopkg.in/juju/charm.v6-unstable"
-	"gopkg.in/juju/charmrepo.v2-unstable"
 )
 
-// handleServices populates the change set with "addCharm"/"addApplication" records.
+// planner holds the information required to generate the changes needed to
+// deploy a bundle onto a model.
+type planner struct {
+	// add is used to add changes to the change set.
+	add func(Change)
+	// bundle holds the bundle data to be deployed.
+	bundle *charm.BundleData
+	// model holds the existing model contents. It is never nil.
+	model *Model
+	// machineMap maps bundle machine ids to existing model machine ids.
+	machineMap map[string]string
+	// prune reports whether the model entities not declared in the bundle
+	// must be removed.
+	prune bool
+	// forceBindings reports whether endpoints must be rebound even if the
+	// application units are already running.
+	forceBindings bool
+	// charmResolver is used to retrieve information about charms.
+	charmResolver CharmResolver
+	// bundleDir holds the directory local charm paths are relative to.
+	bundleDir string
+	// defaultChannel holds the charm store channel used for charms of
+	// applications without a specific channel.
+	defaultChannel string
+	// channels holds the charm store channels for applications, keyed by
+	// application name.
+	channels map[string]string
+	// trusted holds whether applications must be granted access to the
+	// cloud credentials, keyed by application name.
+	trusted map[string]bool
+	// subordinates holds the names of the bundle applications whose charm
+	// is subordinate.
+	subordinates map[string]bool
+	// placementConstraints holds the names of the application constraints
+	// applied to the machines and containers created to host units, or nil
+	// if all the constraints are applied.
+	placementConstraints []string
+	// zones holds the availability zones new machines are distributed
+	// across.
+	zones []string
+	// nextZone holds the index of the zone used for the next new machine.
+	nextZone int
+	// machineZones holds the availability zones of bundle machines, keyed
+	// by bundle machine id.
+	machineZones map[string]string
+	// placementPolicy holds the policy used to place units without
+	// placement directives.
+	placementPolicy PlacementPolicy
+	// unitsPerMachine holds the maximum number of units packed onto each
+	// machine, or zero if there is no limit.
+	unitsPerMachine int
+	// hosts holds the machines units are packed onto by the PackPlacement
+	// policy, lazily initialized.
+	hosts []*placementHost
+}
+
+// handleApplications populates the change set with "addCharm"/"addApplication" records.
 // This function also handles adding application annotations.
-func handleApplications(add func(Change), services map[string]*charm.ApplicationSpec, defaultSeries string) map[string]string {
-	charms := make(map[string]string, len(services))
+func (p *planner) handleApplications() (map[string]string, error) {
+	services := p.bundle.Applications
+	charms := make(map[charmKey]string, len(services))
 	addedServices := make(map[string]string, len(services))
 	// Iterate over the map using its sorted keys so that results are
 	// deterministic and easier to test.
@@ -26,21 +85,39 @@ func handleApplications(add func(Change), services map[string]*charm.Application
 	sort.Strings(names)
 	var change Change
 	for _, name := range names {
-		application := services[name]
-		series := getSeries(application, defaultSeries)
-		// Add the addCharm record if one hasn't been added yet.
-		if charms[application.Charm] == "" {
-			change = newAddCharmChange(AddCharmParams{
-				Charm:  application.Charm,
-				Series: series,
-			})
-			add(change)
-			charms[application.Charm] = change.Id()
+		application, err := p.resolveIncludes(name, services[name])
+		if err != nil {
+			return nil, err
+		}
+		if existing := p.model.application(name); existing != nil {
+			// The application is already deployed: only generate the
+			// changes required to update it.
+			if err := p.updateApplication(name, application, existing, charms); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		series, err := p.getSeries(application)
+		if err != nil {
+			return nil, &CharmError{
+				Application: name,
+				Charm:       application.Charm,
+				Err:         err,
+			}
+		}
+		charmId, channel, err := p.addCharm(name, application, series, charms)
+		if err != nil {
+			return nil, &CharmError{
+				Application: name,
+				Charm:       application.Charm,
+				Err:         err,
+			}
 		}
 
 		// Add the addApplication record for this application.
 		change = newAddApplicationChange(AddApplicationParams{
-			Charm:            "$" + charms[application.Charm],
+			Charm:            "$" + charmId,
+			Channel:          channel,
 			Series:           series,
 			Application:      name,
 			Options:          application.Options,
@@ -48,33 +125,307 @@ func handleApplications(add func(Change), services map[string]*charm.Application
 			Storage:          application.Storage,
 			EndpointBindings: application.EndpointBindings,
 			Resources:        application.Resources,
-		}, charms[application.Charm])
-		add(change)
+		}, charmId)
+		p.add(change)
 		id := change.Id()
 		addedServices[name] = id
 
 		// Expose the application if required.
 		if application.Expose {
-			add(newExposeChange(ExposeParams{
+			p.add(newExposeChange(ExposeParams{
+				Application: "$" + id,
+			}, id))
+		}
+
+		// Trust the application if required.
+		if p.trusted[name] {
+			p.add(newTrustChange(TrustParams{
 				Application: "$" + id,
 			}, id))
 		}
 
 		// Add application annotations.
 		if len(application.Annotations) > 0 {
-			add(newSetAnnotationsChange(SetAnnotationsParams{
+			p.add(newSetAnnotationsChange(SetAnnotationsParams{
 				EntityType:  ApplicationType,
 				Id:          "$" + id,
 				Annotations: application.Annotations,
 			}, id))
 		}
 	}
-	return addedServices
+	return addedServices, nil
+}
+
+// charmKey holds the information identifying a charm to be added, used for
+// deduplicating "addCharm" records. The series is included because the same
+// multi-series charm may be added for different series.
+type charmKey struct {
+	charm   string
+	series  string
+	channel string
+}
+
+// addCharm adds an "addCharm" record, or an "uploadCharm" record for local
+// charms, for the charm of the given application if one hasn't been added
+// yet. It returns the id of the change adding the charm and the charm store
+// channel the charm is retrieved from.
+func (p *planner) addCharm(name string, application *charm.ApplicationSpec, series string, charms map[charmKey]string) (id, channel string, err error) {
+	path := p.charmPath(application.Charm)
+	info, err := p.charmResolver.ResolveCharm(path)
+	if err != nil {
+		return "", "", err
+	}
+	local := info != nil && info.Local
+	key := charmKey{
+		charm:  path,
+		series: series,
+	}
+	if !local {
+		// Channels only apply to charms retrieved from the charm store.
+		if key.channel, err = p.channel(name); err != nil {
+			return "", "", err
+		}
+	}
+	if id := charms[key]; id != "" {
+		return id, key.channel, nil
+	}
+	var change Change
+	if local {
+		change = newUploadCharmChange(UploadCharmParams{
+			Path:   path,
+			SHA256: info.ArchiveSHA256,
+			Series: series,
+		})
+	} else {
+		change = newAddCharmChange(AddCharmParams{
+			Charm:   path,
+			Series:  series,
+			Channel: key.channel,
+		})
+	}
+	p.add(change)
+	charms[key] = change.Id()
+	return change.Id(), key.channel, nil
+}
+
+// channel returns the charm store channel to be used for the charm of the
+// application with the given name, or an empty string if the default channel
+// must be used.
+func (p *planner) channel(name string) (string, error) {
+	channel := p.channels[name]
+	if channel == "" {
+		channel = p.defaultChannel
+	}
+	if channel != "" && !validChannels[channel] {
+		return "", fmt.Errorf("invalid channel %q", channel)
+	}
+	return channel, nil
+}
+
+// validChannels holds the charm store channels charms can be retrieved from.
+var validChannels = map[string]bool{
+	"stable":    true,
+	"candidate": true,
+	"beta":      true,
+	"edge":      true,
+}
+
+// updateApplication populates the change set with the records required to
+// bring the given existing application in line with its bundle definition.
+func (p *planner) updateApplication(name string, application *charm.ApplicationSpec, existing *Application, charms map[charmKey]string) error {
+	// Upgrade the application if the bundle specifies a different charm.
+	// Subsequent changes to the application require the upgrade, as they may
+	// rely on the new charm.
+	var requires []string
+	series, err := p.getSeries(application)
+	if err != nil {
+		return &CharmError{
+			Application: name,
+			Charm:       application.Charm,
+			Err:         err,
+		}
+	}
+	changed, err := p.charmChanged(application.Charm, series, existing)
+	if err != nil {
+		return &CharmError{
+			Application: name,
+			Charm:       application.Charm,
+			Err:         err,
+		}
+	}
+	if changed {
+		charmId, channel, err := p.addCharm(name, application, series, charms)
+		if err != nil {
+			return &CharmError{
+				Application: name,
+				Charm:       application.Charm,
+				Err:         err,
+			}
+		}
+		change := newUpgradeCharmChange(UpgradeCharmParams{
+			Charm:       "$" + charmId,
+			Application: name,
+			Series:      series,
+			Channel:     channel,
+		}, charmId)
+		p.add(change)
+		requires = append(requires, change.Id())
+	}
+
+	// Update the resources whose revisions differ from the existing ones.
+	resources := make([]string, 0, len(application.Resources))
+	for resource := range application.Resources {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	for _, resource := range resources {
+		revision := application.Resources[resource]
+		if r, ok := existing.Resources[resource]; ok && r == revision {
+			continue
+		}
+		p.add(newUpdateResourceChange(UpdateResourceParams{
+			Application: name,
+			Resource:    resource,
+			Revision:    revision,
+		}, requires...))
+	}
+
+	// Set the endpoint bindings which differ from the existing ones.
+	if bindings := changedStrings(application.EndpointBindings, existing.EndpointBindings); len(bindings) > 0 {
+		p.add(newSetBindingsChange(SetBindingsParams{
+			Application:      name,
+			EndpointBindings: bindings,
+			Force:            p.forceBindings,
+		}, requires...))
+	}
+
+	// Set the application options which differ from the existing ones.
+	if options := changedOptions(application.Options, existing.Options); len(options) > 0 {
+		p.add(newSetOptionsChange(SetOptionsParams{
+			Application: name,
+			Options:     options,
+		}, requires...))
+	}
+
+	// Set the application constraints if they differ from the existing ones.
+	if !constraintsEqual(application.Constraints, existing.Constraints) {
+		p.add(newSetConstraintsChange(SetConstraintsParams{
+			Id:          name,
+			EntityType:  ApplicationType,
+			Constraints: application.Constraints,
+		}))
+	}
+
+	// Expose or unexpose the application if its exposure differs from the
+	// bundle one.
+	switch {
+	case application.Expose && !existing.Exposed:
+		p.add(newExposeChange(ExposeParams{
+			Application: name,
+		}))
+	case !application.Expose && existing.Exposed:
+		p.add(newUnexposeChange(UnexposeParams{
+			Application: name,
+		}))
+	}
+
+	// Trust or untrust the application if the bundle declares a different
+	// trust. Applications not declaring trust are left as they are.
+	if trusted, ok := p.trusted[name]; ok {
+		switch {
+		case trusted && !existing.Trusted:
+			p.add(newTrustChange(TrustParams{
+				Application: name,
+			}))
+		case !trusted && existing.Trusted:
+			p.add(newUntrustChange(UntrustParams{
+				Application: name,
+			}))
+		}
+	}
+
+	// Update application annotations.
+	p.updateAnnotations(name, ApplicationType, application.Annotations, existing.Annotations)
+	return nil
+}
+
+// charmChanged reports whether the given bundle charm, deployed with the
+// given series, differs from the charm used by the given existing
+// application. Charm store URLs are compared by schema, user, name, series
+// and revision, where a missing series or revision matches any. Local charms
+// are uploaded with a new revision every time, so they are only compared by
+// name: changes to the contents of a local charm are not detected.
+func (p *planner) charmChanged(ref, series string, existing *Application) (bool, error) {
+	existingURL, err := charm.ParseURL(existing.Charm)
+	if err != nil {
+		// The model charm URL cannot be parsed: compare it verbatim.
+		return ref != existing.Charm, nil
+	}
+	existingSeries := existingURL.Series
+	if existingSeries == "" {
+		existingSeries = existing.Series
+	}
+	if isLocalCharm(ref) {
+		path := p.charmPath(ref)
+		info, err := p.charmResolver.ResolveCharm(path)
+		if err != nil {
+			return false, err
+		}
+		name := filepath.Base(path)
+		if info != nil && info.Meta != nil && info.Meta.Name != "" {
+			name = info.Meta.Name
+		}
+		return existingURL.Schema != "local" || existingURL.Name != name ||
+			!seriesMatch(series, existingSeries), nil
+	}
+	curl, err := charm.ParseURL(ref)
+	if err != nil {
+		return false, err
+	}
+	if curl.Series == "" {
+		curl.Series = series
+	}
+	return curl.Schema != existingURL.Schema ||
+		curl.User != existingURL.User ||
+		curl.Name != existingURL.Name ||
+		!seriesMatch(curl.Series, existingSeries) ||
+		(curl.Revision != -1 && curl.Revision != existingURL.Revision), nil
+}
+
+// seriesMatch reports whether the two given series are the same. An empty
+// series matches any series.
+func seriesMatch(series1, series2 string) bool {
+	return series1 == "" || series2 == "" || series1 == series2
+}
+
+// updateAnnotations populates the change set with the records required to
+// update the annotations of the existing entity with the given id: only the
+// annotations whose values differ from the existing ones are set and, when
+// pruning, the existing annotations not declared in the bundle are removed.
+func (p *planner) updateAnnotations(id string, entityType EntityType, annotations, existing map[string]string) {
+	if changed := changedStrings(annotations, existing); len(changed) > 0 {
+		p.add(newSetAnnotationsChange(SetAnnotationsParams{
+			EntityType:  entityType,
+			Id:          id,
+			Annotations: changed,
+		}))
+	}
+	if !p.prune {
+		return
+	}
+	if removed := removedAnnotations(annotations, existing); len(removed) > 0 {
+		p.add(newRemoveAnnotationsChange(RemoveAnnotationsParams{
+			EntityType: entityType,
+			Id:         id,
+			Keys:       removed,
+		}))
+	}
 }
 
 // handleMachines populates the change set with "addMachines" records.
 // This function also handles adding machine annotations.
-func handleMachines(add func(Change), machines map[string]*charm.MachineSpec, defaultSeries string) map[string]string {
+func (p *planner) handleMachines() map[string]string {
+	machines := p.bundle.Machines
 	addedMachines := make(map[string]string, len(machines))
 	// Iterate over the map using its sorted keys so that results are
 	// deterministic and easier to test.
@@ -89,21 +440,46 @@ func handleMachines(add func(Change), machines map[string]*charm.MachineSpec, de
 		if machine == nil {
 			machine = &charm.MachineSpec{}
 		}
+		if id, ok := p.machineMap[name]; ok {
+			// The machine already exists in the model: only set its
+			// constraints and annotations if they differ from the existing
+			// ones. Machines without constraints in the bundle keep the
+			// existing constraints, as mapping a bundle machine onto a model
+			// machine must not clear them.
+			existing := p.model.machine(id)
+			if existing == nil {
+				existing = &Machine{}
+			}
+			if machine.Constraints != "" && !constraintsEqual(machine.Constraints, existing.Constraints) {
+				p.add(newSetConstraintsChange(SetConstraintsParams{
+					Id:          id,
+					EntityType:  MachineType,
+					Constraints: machine.Constraints,
+				}))
+			}
+			p.updateAnnotations(id, MachineType, machine.Annotations, existing.Annotations)
+			continue
+		}
 		series := machine.Series
 		if series == "" {
-			series = defaultSeries
+			series = p.bundle.Series
 		}
 		// Add the addMachines record for this machine.
+		zone := p.machineZones[name]
+		if zone == "" {
+			zone = p.zone()
+		}
 		change = newAddMachineChange(AddMachineParams{
 			Series:      series,
 			Constraints: machine.Constraints,
+			Zone:        zone,
 		})
-		add(change)
+		p.add(change)
 		addedMachines[name] = change.Id()
 
 		// Add machine annotations.
 		if len(machine.Annotations) > 0 {
-			add(newSetAnnotationsChange(SetAnnotationsParams{
+			p.add(newSetAnnotationsChange(SetAnnotationsParams{
 				EntityType:  MachineType,
 				Id:          "$" + change.Id(),
 				Annotations: machine.Annotations,
@@ -114,19 +490,23 @@ func handleMachines(add func(Change), machines map[string]*charm.MachineSpec, de
 }
 
 // handleRelations populates the change set with "addRelation" records.
-func handleRelations(add func(Change), relations [][]string, addedServices map[string]string) {
-	for _, relation := range relations {
+// Relations already established in the model are skipped.
+func (p *planner) handleRelations(addedServices map[string]string) {
+	for _, relation := range p.bundle.Relations {
+		if p.model.hasRelation(relation[0], relation[1]) {
+			continue
+		}
 		// Add the addRelation record for this relation pair.
 		args := make([]string, 2)
-		requires := make([]string, 2)
+		var requires []string
 		for i, endpoint := range relation {
 			ep := parseEndpoint(endpoint)
-			application := addedServices[ep.application]
-			requires[i] = application
-			ep.application = application
-			args[i] = "$" + ep.String()
+			ref := applicationRef(ep.application, addedServices)
+			requires = append(requires, refRequires(ref)...)
+			ep.application = ref
+			args[i] = ep.String()
 		}
-		add(newAddRelationChange(AddRelationParams{
+		p.add(newAddRelationChange(AddRelationParams{
 			Endpoint1: args[0],
 			Endpoint2: args[1],
 		}, requires...))
@@ -135,8 +515,17 @@ func handleRelations(add func(Change), relations [][]string, addedServices map[s
 
 // handleUnits populates the change set with "addUnit" records.
 // It also handles adding machine containers where to place units if required.
-func handleUnits(add func(Change), services map[string]*charm.ApplicationSpec, addedServices, addedMachines map[string]string, defaultSeries string) {
+// Units already present in the model are not added again.
+func (p *planner) handleUnits(addedServices, addedMachines map[string]string) error {
+	services := p.bundle.Applications
 	records := make(map[string]*AddUnitChange)
+	// existingUnits maps bundle unit names to the machines where the
+	// corresponding model units are deployed.
+	existingUnits := make(map[string]string)
+	// colocations maps the names of the units added by the bundle to the
+	// added units they are placed to, so that placement cycles can be
+	// detected.
+	colocations := make(map[string]colocation)
 	// Iterate over the map using its sorted keys so that results are
 	// deterministic and easier to test.
 	names := make([]string, 0, len(services))
@@ -148,26 +537,57 @@ func handleUnits(add func(Change), services map[string]*charm.ApplicationSpec, a
 	// modified later in order to handle unit placement.
 	for _, name := range names {
 		application := services[name]
+		existing := p.model.existingUnits(name)
 		for i := 0; i < application.NumUnits; i++ {
-			addedApplication := addedServices[name]
+			unit := fmt.Sprintf("%s/%d", name, i)
+			if machine, ok := existing[unit]; ok {
+				existingUnits[unit] = machine
+				continue
+			}
+			ref := applicationRef(name, addedServices)
 			change := newAddUnitChange(AddUnitParams{
-				Application: "$" + addedApplication,
-			}, addedApplication)
-			add(change)
-			records[fmt.Sprintf("%s/%d", name, i)] = change
+				Application: ref,
+			}, refRequires(ref)...)
+			p.add(change)
+			records[unit] = change
 		}
 	}
 	// Now handle unit placement for each added application unit.
 	for _, name := range names {
 		application := services[name]
 		numPlaced := len(application.To)
-		if numPlaced == 0 {
+		if numPlaced == 0 && (application.NumUnits == 0 || p.placementPolicy == ProviderPlacement) {
 			// If there are no placement directives it means that either the
 			// application has no units (in which case there is no need to
 			// proceed), or the units are not placed (in which case there is no
 			// need to modify the change already added above).
 			continue
 		}
+		series, err := p.getSeries(application)
+		if err != nil {
+			return &CharmError{
+				Application: name,
+				Charm:       application.Charm,
+				Err:         err,
+			}
+		}
+		// Machines and containers created to host the units inherit the
+		// application constraints.
+		constraints := selectConstraints(application.Constraints, p.placementConstraints)
+		if numPlaced == 0 {
+			// Place the units according to the placement policy.
+			for i := 0; i < application.NumUnits; i++ {
+				change := records[fmt.Sprintf("%s/%d", name, i)]
+				if change == nil {
+					// The unit already exists in the model.
+					continue
+				}
+				parent := p.policyParent(addedMachines, series, constraints)
+				change.requires = append(change.requires, refRequires(parent)...)
+				change.Params.To = parent
+			}
+			continue
+		}
 		// servicePlacedUnits holds, for each application, the number of units of
 		// the current application already placed to that application.
 		servicePlacedUnits := make(map[string]int)
@@ -175,98 +595,511 @@ func handleUnits(add func(Change), services map[string]*charm.ApplicationSpec, a
 		// Fill the other ones if required.
 		lastPlacement := application.To[numPlaced-1]
 		for i := 0; i < application.NumUnits; i++ {
-			p := lastPlacement
+			placement := lastPlacement
 			if i < numPlaced {
-				p = application.To[i]
+				placement = application.To[i]
 			}
-			// Generate the changes required in order to place this unit, and
-			// retrieve the identifier of the parent change.
-			parentId := unitParent(add, p, records, addedMachines, servicePlacedUnits, getSeries(application, defaultSeries))
-			// Retrieve and modify the original "addUnit" change to add the
-			// new parent requirement and placement target.
 			change := records[fmt.Sprintf("%s/%d", name, i)]
-			change.requires = append(change.requires, parentId)
-			change.Params.To = "$" + parentId
+			if change == nil {
+				// The unit already exists in the model, and therefore it is
+				// already placed. Only keep track of co-located units.
+				if err := skipPlacement(placement, servicePlacedUnits); err != nil {
+					return &PlacementError{
+						Application: name,
+						Directive:   placement,
+						Err:         err,
+					}
+				}
+				continue
+			}
+			// Generate the changes required in order to place this unit, and
+			// retrieve the reference to the parent machine or unit.
+			parent, target, err := p.unitParent(placement, records, existingUnits, addedMachines, servicePlacedUnits, series, constraints)
+			if err != nil {
+				return &PlacementError{
+					Application: name,
+					Directive:   placement,
+					Err:         err,
+				}
+			}
+			if target != "" {
+				colocations[fmt.Sprintf("%s/%d", name, i)] = colocation{
+					application: name,
+					directive:   placement,
+					unit:        target,
+				}
+			}
+			// Modify the original "addUnit" change to add the new parent
+			// requirement and placement target.
+			change.requires = append(change.requires, refRequires(parent)...)
+			change.Params.To = parent
 		}
 	}
+	return checkPlacementCycles(colocations)
 }
 
-func unitParent(add func(Change), p string, records map[string]*AddUnitChange, addedMachines map[string]string, servicePlacedUnits map[string]int, series string) (parentId string) {
-	placement, err := charm.ParsePlacement(p)
+// colocation holds the placement of a unit added by the bundle to another
+// unit added by the bundle.
+type colocation struct {
+	// application holds the name of the application of the placed unit.
+	application string
+	// directive holds the placement directive used to place the unit.
+	directive string
+	// unit holds the name of the unit the unit is placed to.
+	unit string
+}
+
+// checkPlacementCycles returns a *PlacementError if units added by the
+// bundle are placed to each other, directly or through other units, in which
+// case none of them can be added.
+func checkPlacementCycles(colocations map[string]colocation) error {
+	// Iterate over the map using its sorted keys so that errors are
+	// deterministic and easier to test.
+	units := make([]string, 0, len(colocations))
+	for unit, _ := range colocations {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+	for _, unit := range units {
+		path := []string{unit}
+		visited := map[string]bool{unit: true}
+		for current := unit; ; {
+			c, ok := colocations[current]
+			if !ok {
+				break
+			}
+			path = append(path, c.unit)
+			if c.unit == unit {
+				start := colocations[unit]
+				return &PlacementError{
+					Application: start.application,
+					Directive:   start.directive,
+					Err:         fmt.Errorf("placement cycle: %s", strings.Join(path, " -> ")),
+				}
+			}
+			if visited[c.unit] {
+				// The cycle does not include this unit, and it is reported
+				// when starting from one of its units.
+				break
+			}
+			visited[c.unit] = true
+			current = c.unit
+		}
+	}
+	return nil
+}
+
+// unitParent generates the changes required to place a unit using the given
+// placement directive, and returns a reference to the parent machine or unit.
+// The reference is either a placeholder pointing to a change (like
+// "$addMachines-2") or the id of an existing model machine. If the unit is
+// placed to another unit added by the bundle, the name of that unit is
+// returned as target.
+func (p *planner) unitParent(directive string, records map[string]*AddUnitChange, existingUnits, addedMachines map[string]string, servicePlacedUnits map[string]int, series, constraints string) (parent, target string, err error) {
+	if zone, ok := parseZone(directive); ok {
+		// The unit is placed to a new machine in the given zone.
+		if zone == "" {
+			return "", "", errors.New("availability zone not specified")
+		}
+		change := newAddMachineChange(AddMachineParams{
+			Series:      series,
+			Constraints: constraints,
+			Zone:        zone,
+		})
+		p.add(change)
+		return "$" + change.Id(), "", nil
+	}
+	placement, err := charm.ParsePlacement(directive)
 	if err != nil {
-		// Since the bundle is already verified, this should never happen.
-		panic(err)
+		return "", "", err
 	}
 	if placement.Machine == "new" {
 		// The unit is placed to a new machine.
 		change := newAddMachineChange(AddMachineParams{
 			ContainerType: placement.ContainerType,
 			Series:        series,
+			Constraints:   constraints,
+			Zone:          p.zone(),
 		})
-		add(change)
-		return change.Id()
+		p.add(change)
+		return "$" + change.Id(), "", nil
 	}
 	if placement.Machine != "" {
 		// The unit is placed to a machine declared in the bundle.
-		parentId = addedMachines[placement.Machine]
+		if id, ok := p.machineMap[placement.Machine]; ok {
+			parent = id
+		} else if id, ok := addedMachines[placement.Machine]; ok {
+			parent = "$" + id
+		} else {
+			return "", "", fmt.Errorf("machine %q not declared in the bundle", placement.Machine)
+		}
 		if placement.ContainerType != "" {
-			parentId = addContainer(add, placement.ContainerType, parentId, series)
+			parent = p.addContainer(placement.ContainerType, parent, series, constraints)
 		}
-		return parentId
+		return parent, "", nil
 	}
 	// The unit is placed to another unit or to an application.
 	number := placement.Unit
 	if number == -1 {
 		// The unit is placed to an application. Calculate the unit number to be
 		// used for unit co-location.
-		if n, ok := servicePlacedUnits[placement.Application]; ok {
-			number = n + 1
-		} else {
-			number = 0
-		}
-		servicePlacedUnits[placement.Application] = number
+		number = nextPlacedUnit(placement.Application, servicePlacedUnits)
 	}
 	otherUnit := fmt.Sprintf("%s/%d", placement.Application, number)
-	parentId = records[otherUnit].Id()
+	if change := records[otherUnit]; change != nil {
+		parent = "$" + change.Id()
+		target = otherUnit
+	} else if machine, ok := existingUnits[otherUnit]; ok {
+		// The unit is placed to a unit which already exists in the model.
+		parent = machine
+	} else {
+		return "", "", fmt.Errorf("unit %q not declared in the bundle", otherUnit)
+	}
 	if placement.ContainerType != "" {
-		parentId = addContainer(add, placement.ContainerType, parentId, series)
+		parent = p.addContainer(placement.ContainerType, parent, series, constraints)
+	}
+	return parent, target, nil
+}
+
+// skipPlacement updates the co-location counters for a unit which is already
+// present in the model, so that subsequent units are placed as if the unit
+// had been added by the bundle.
+func skipPlacement(directive string, servicePlacedUnits map[string]int) error {
+	if _, ok := parseZone(directive); ok {
+		return nil
+	}
+	placement, err := charm.ParsePlacement(directive)
+	if err != nil {
+		return err
+	}
+	if placement.Machine == "" && placement.Unit == -1 {
+		nextPlacedUnit(placement.Application, servicePlacedUnits)
+	}
+	return nil
+}
+
+// zonePrefix prefixes placement directives targeting a new machine in a
+// specific availability zone, like "zone=us-east-1a".
+const zonePrefix = "zone="
+
+// parseZone returns the availability zone specified by the given placement
+// directive, reporting whether the directive is a zone directive.
+func parseZone(directive string) (zone string, ok bool) {
+	if !strings.HasPrefix(directive, zonePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(directive, zonePrefix), true
+}
+
+// zone returns the availability zone for the next new machine, cycling
+// through the configured zones, or an empty string if no zones are
+// configured.
+func (p *planner) zone() string {
+	if len(p.zones) == 0 {
+		return ""
 	}
-	return parentId
+	zone := p.zones[p.nextZone%len(p.zones)]
+	p.nextZone++
+	return zone
 }
 
-func addContainer(add func(Change), containerType, parentId string, series string) string {
+// nextPlacedUnit returns the number of the unit of the given application to
+// be used for co-locating the next unit, and records it in
+// servicePlacedUnits.
+func nextPlacedUnit(application string, servicePlacedUnits map[string]int) int {
+	number := 0
+	if n, ok := servicePlacedUnits[application]; ok {
+		number = n + 1
+	}
+	servicePlacedUnits[application] = number
+	return number
+}
+
+// addContainer adds a container of the given type, series and constraints to
+// the given parent machine or unit reference, and returns the reference to the
+// new container.
+func (p *planner) addContainer(containerType, parent, series, constraints string) string {
 	change := newAddMachineChange(AddMachineParams{
 		ContainerType: containerType,
-		ParentId:      "$" + parentId,
+		ParentId:      parent,
 		Series:        series,
-	}, parentId)
-	add(change)
-	return change.Id()
+		Constraints:   constraints,
+	}, refRequires(parent)...)
+	p.add(change)
+	return "$" + change.Id()
+}
+
+// handlePrune populates the change set with the records required to remove
+// the model entities not declared in the bundle: relations, applications,
+// units exceeding the number declared for their application, and machines
+// not hosting any remaining unit. Relations are removed before applications,
+// and units before the machines they are deployed to.
+func (p *planner) handlePrune() {
+	// Remove the relations not declared in the bundle, keeping track of the
+	// removals required before destroying each application.
+	relationRemovals := make(map[string][]string)
+	for _, r := range p.model.Relations {
+		if p.relationDeclared(r) {
+			continue
+		}
+		change := newRemoveRelationChange(RemoveRelationParams{
+			Endpoint1: r.Endpoint1,
+			Endpoint2: r.Endpoint2,
+		})
+		p.add(change)
+		for _, e := range []string{r.Endpoint1, r.Endpoint2} {
+			name := parseEndpoint(e).application
+			relationRemovals[name] = append(relationRemovals[name], change.Id())
+		}
+	}
+
+	// unitRemovals maps machine ids to the ids of the changes removing the
+	// units deployed to those machines.
+	unitRemovals := make(map[string][]string)
+	// keptMachines holds the ids of the machines which are used by the bundle.
+	keptMachines := make(map[string]bool)
+	for _, id := range p.machineMap {
+		keptMachines[id] = true
+	}
+	// Iterate over the map using its sorted keys so that results are
+	// deterministic and easier to test.
+	names := make([]string, 0, len(p.model.Applications))
+	for name, _ := range p.model.Applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		units := p.model.sortedUnits(name)
+		application := p.bundle.Applications[name]
+		if application == nil {
+			// Destroy the application, which also removes its units.
+			change := newDestroyApplicationChange(DestroyApplicationParams{
+				Application: name,
+			}, relationRemovals[name]...)
+			p.add(change)
+			for _, u := range units {
+				unitRemovals[u.Machine] = append(unitRemovals[u.Machine], change.Id())
+			}
+			continue
+		}
+		if p.isSubordinate(name) {
+			// Subordinate units come and go with their principal units.
+			continue
+		}
+		for i, u := range units {
+			if i < application.NumUnits {
+				keptMachines[u.Machine] = true
+				continue
+			}
+			change := newRemoveUnitChange(RemoveUnitParams{
+				Unit: u.Name,
+			})
+			p.add(change)
+			unitRemovals[u.Machine] = append(unitRemovals[u.Machine], change.Id())
+		}
+	}
+
+	// Destroy the machines which are not used by the bundle. Machine ids are
+	// processed in reverse order so that containers are destroyed before
+	// their parent machines.
+	ids := make([]string, 0, len(p.model.Machines))
+	for id, _ := range p.model.Machines {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	machineRemovals := make(map[string][]string)
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		if machineUsed(id, keptMachines) {
+			continue
+		}
+		requires := append(machineRequires(id, unitRemovals), machineRequires(id, machineRemovals)...)
+		change := newDestroyMachineChange(DestroyMachineParams{
+			Machine: id,
+		}, requires...)
+		p.add(change)
+		machineRemovals[id] = []string{change.Id()}
+	}
+}
+
+// relationDeclared reports whether the given model relation is declared in
+// the bundle.
+func (p *planner) relationDeclared(r Relation) bool {
+	for _, relation := range p.bundle.Relations {
+		if r.matches(relation[0], relation[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// machineUsed reports whether the machine with the given id, or one of its
+// containers, is included in the given used machines.
+func machineUsed(id string, used map[string]bool) bool {
+	for usedId := range used {
+		if usedId == id || strings.HasPrefix(usedId, id+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// machineRequires returns, in a deterministic order, the change ids
+// associated with the machine with the given id or any of its containers.
+func machineRequires(id string, removals map[string][]string) []string {
+	var requires []string
+	for removalId, changeIds := range removals {
+		if removalId == id || strings.HasPrefix(removalId, id+"/") {
+			requires = append(requires, changeIds...)
+		}
+	}
+	sort.Strings(requires)
+	return requires
+}
+
+// applicationRef returns the reference to be used in change parameters for
+// the application with the given name: a placeholder pointing to the
+// application change if the application is added by the bundle, or the
+// application name itself if the application already exists in the model.
+func applicationRef(name string, addedServices map[string]string) string {
+	if id := addedServices[name]; id != "" {
+		return "$" + id
+	}
+	return name
+}
+
+// refRequires returns the change ids required by the given reference: a
+// placeholder (like "$deploy-1") requires the change it points to, while a
+// reference to an existing model entity does not require any change.
+func refRequires(ref string) []string {
+	if strings.HasPrefix(ref, "$") {
+		return []string{ref[1:]}
+	}
+	return nil
+}
+
+// changedStrings returns the values, like annotations or endpoint bindings,
+// which are not present or are different in the existing values.
+func changedStrings(values, existing map[string]string) map[string]string {
+	var changed map[string]string
+	for key, value := range values {
+		if v, ok := existing[key]; ok && v == value {
+			continue
+		}
+		if changed == nil {
+			changed = make(map[string]string)
+		}
+		changed[key] = value
+	}
+	return changed
+}
+
+// removedAnnotations returns the sorted keys of the existing annotations
+// which are not present in the given annotations.
+func removedAnnotations(annotations, existing map[string]string) []string {
+	var removed []string
+	for key := range existing {
+		if _, ok := annotations[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// changedOptions returns the options whose values differ from the existing
+// ones, or nil if all the options are already set.
+func changedOptions(options, existing map[string]interface{}) map[string]interface{} {
+	var changed map[string]interface{}
+	for key, value := range options {
+		if v, ok := existing[key]; ok && optionValuesEqual(value, v) {
+			continue
+		}
+		if changed == nil {
+			changed = make(map[string]interface{})
+		}
+		changed[key] = value
+	}
+	return changed
+}
+
+// optionValuesEqual reports whether the given option values are equal.
+// Numeric values are compared regardless of their type, as the model
+// snapshot may have been decoded from a format other than YAML.
+func optionValuesEqual(v1, v2 interface{}) bool {
+	f1, ok1 := floatValue(v1)
+	f2, ok2 := floatValue(v2)
+	if ok1 && ok2 {
+		return f1 == f2
+	}
+	return reflect.DeepEqual(v1, v2)
+}
+
+// floatValue returns the given numeric value as a float64.
+func floatValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// charmPath returns the given charm URL, or the absolute path of the charm if
+// the given charm is a local charm path relative to the bundle directory.
+func (p *planner) charmPath(ref string) string {
+	if !isLocalCharm(ref) {
+		return ref
+	}
+	path := ref
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.bundleDir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return path
+}
+
+// isLocalCharm reports whether the given charm reference is a local charm
+// path, for instance "./charms/mysql" or "/path/to/mysql".
+func isLocalCharm(ref string) bool {
+	return strings.HasPrefix(ref, ".") || filepath.IsAbs(ref)
 }
 
 // getSeries retrieves the series of a application from the ApplicationSpec or from the
-// charm path or URL if provided, otherwise falling back on a default series.
-func getSeries(application *charm.ApplicationSpec, defaultSeries string) string {
+// charm information or URL if provided, otherwise falling back on the bundle
+// default series. An error is returned if the charm cannot be resolved, or if
+// it is neither a local charm nor a valid URL.
+func (p *planner) getSeries(application *charm.ApplicationSpec) (string, error) {
 	if application.Series != "" {
-		return application.Series
+		return application.Series, nil
 	}
-	// We may have a local charm path.
-	_, curl, err := charmrepo.NewCharmAtPath(application.Charm, "")
-	if charm.IsMissingSeriesError(err) {
-		// local charm path is valid but the charm doesn't declare a default series.
-		return defaultSeries
+	info, err := p.charmResolver.ResolveCharm(p.charmPath(application.Charm))
+	if err != nil {
+		return "", err
 	}
-	if err == nil {
-		// Return the default series from the local charm.
-		return curl.Series
+	if info != nil {
+		if info.Series != "" {
+			// Return the default series declared by the charm.
+			return info.Series, nil
+		}
+		if info.Local {
+			// The local charm doesn't declare a default series.
+			return p.bundle.Series, nil
+		}
+	}
+	curl, err := charm.ParseURL(application.Charm)
+	if err != nil {
+		return "", err
 	}
-	// The following is safe because the bundle data is assumed to be already
-	// verified, and therefore this must be a valid charm URL.
-	series := charm.MustParseURL(application.Charm).Series
-	if series != "" {
-		return series
+	if curl.Series != "" {
+		return curl.Series, nil
 	}
-	return defaultSeries
+	return p.bundle.Series, nil
 }
 
 // parseEndpoint creates an endpoint from its string representation.
@@ -0,0 +1,115 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/juju/charm.v6-unstable"
+)
+
+const (
+	// includeFilePrefix prefixes option and annotation values replaced with
+	// the contents of the file at the given path.
+	includeFilePrefix = "include-file://"
+	// includeBase64Prefix prefixes option and annotation values replaced with
+	// the base64 encoded contents of the file at the given path.
+	includeBase64Prefix = "include-base64://"
+)
+
+// resolveIncludes returns the given application with the files included in
+// its option and annotation values resolved. The given application is not
+// modified, and is returned as is if no files are included.
+func (p *planner) resolveIncludes(name string, application *charm.ApplicationSpec) (*charm.ApplicationSpec, error) {
+	var options map[string]interface{}
+	for key, value := range application.Options {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		content, included, err := p.resolveInclude(s)
+		if err != nil {
+			return nil, &IncludeError{
+				Application: name,
+				Kind:        "option",
+				Key:         key,
+				Err:         err,
+			}
+		}
+		if !included {
+			continue
+		}
+		if options == nil {
+			options = make(map[string]interface{}, len(application.Options))
+			for k, v := range application.Options {
+				options[k] = v
+			}
+		}
+		options[key] = content
+	}
+	var annotations map[string]string
+	for key, value := range application.Annotations {
+		content, included, err := p.resolveInclude(value)
+		if err != nil {
+			return nil, &IncludeError{
+				Application: name,
+				Kind:        "annotation",
+				Key:         key,
+				Err:         err,
+			}
+		}
+		if !included {
+			continue
+		}
+		if annotations == nil {
+			annotations = make(map[string]string, len(application.Annotations))
+			for k, v := range application.Annotations {
+				annotations[k] = v
+			}
+		}
+		annotations[key] = content
+	}
+	if options == nil && annotations == nil {
+		return application, nil
+	}
+	resolved := *application
+	if options != nil {
+		resolved.Options = options
+	}
+	if annotations != nil {
+		resolved.Annotations = annotations
+	}
+	return &resolved, nil
+}
+
+// resolveInclude returns the contents of the file included by the given
+// value, reporting whether the value includes a file at all. Relative paths
+// are resolved relative to the bundle directory.
+func (p *planner) resolveInclude(value string) (content string, included bool, err error) {
+	var path string
+	var encode bool
+	switch {
+	case strings.HasPrefix(value, includeFilePrefix):
+		path = strings.TrimPrefix(value, includeFilePrefix)
+	case strings.HasPrefix(value, includeBase64Prefix):
+		path = strings.TrimPrefix(value, includeBase64Prefix)
+		encode = true
+	default:
+		return "", false, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.bundleDir, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", true, err
+	}
+	if encode {
+		return base64.StdEncoding.EncodeToString(data), true, nil
+	}
+	return string(data), true, nil
+}
@@ -0,0 +1,322 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/juju/charm.v6-unstable"
+)
+
+// Model holds a snapshot of the entities already present in a Juju model.
+// It is used to compute the changes required to deploy a bundle onto a model
+// which is not empty.
+type Model struct {
+	// Applications holds the applications in the model, keyed by name.
+	Applications map[string]*Application
+	// Machines holds the machines in the model, keyed by machine id.
+	Machines map[string]*Machine
+	// Relations holds the relations established in the model.
+	Relations []Relation
+}
+
+// Application holds information about an application in the model.
+type Application struct {
+	// Charm holds the URL of the charm used by the application.
+	Charm string
+	// Series holds the series of the application. When specified, it is
+	// used to validate the series of the machines where new units of the
+	// application are placed, and of the units co-located with them.
+	Series string
+	// Options holds the application configuration options.
+	Options map[string]interface{}
+	// Constraints holds the application constraints.
+	Constraints string
+	// Exposed reports whether the application is exposed.
+	Exposed bool
+	// Trusted reports whether the application has been granted access to
+	// the cloud credentials.
+	Trusted bool
+	// Annotations holds the application annotations.
+	Annotations map[string]string
+	// Resources holds the revision of each resource used by the
+	// application, keyed by resource name.
+	Resources map[string]int
+	// EndpointBindings holds the spaces the application endpoints are
+	// bound to, keyed by endpoint name.
+	EndpointBindings map[string]string
+	// Units holds the units of the application.
+	Units []Unit
+	// Subordinate reports whether the application charm is subordinate.
+	// Subordinate units are not removed when pruning, and are not counted
+	// when packing units onto machines, as they come and go with their
+	// principal units.
+	Subordinate bool
+}
+
+// Unit holds information about an application unit in the model.
+type Unit struct {
+	// Name holds the unit name, for instance "mysql/0".
+	Name string
+	// Machine holds the id of the machine or container where the unit is
+	// deployed, for instance "1" or "1/lxd/0".
+	Machine string
+}
+
+// Machine holds information about a machine in the model.
+type Machine struct {
+	// Series holds the machine OS series.
+	Series string
+	// Constraints holds the machine constraints.
+	Constraints string
+	// Annotations holds the machine annotations.
+	Annotations map[string]string
+}
+
+// Relation holds a relation established in the model. Endpoints are in the
+// "application:relation" form, for instance "mysql:db".
+type Relation struct {
+	Endpoint1 string
+	Endpoint2 string
+}
+
+// application returns the model application with the given name, or nil if
+// the application is not present in the model.
+func (m *Model) application(name string) *Application {
+	if m == nil {
+		return nil
+	}
+	return m.Applications[name]
+}
+
+// machine returns the model machine with the given id, or nil if the machine
+// is not present in the model.
+func (m *Model) machine(id string) *Machine {
+	if m == nil {
+		return nil
+	}
+	return m.Machines[id]
+}
+
+// hasRelation reports whether a relation between the two given endpoints is
+// already established in the model.
+func (m *Model) hasRelation(endpoint1, endpoint2 string) bool {
+	if m == nil {
+		return false
+	}
+	for _, r := range m.Relations {
+		if r.matches(endpoint1, endpoint2) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the relation is established between the two given
+// endpoints, in any order. Endpoints are compared after normalization, so
+// that an endpoint without a relation name, like "mysql", matches any
+// relation of the same application, like "mysql:db".
+func (r Relation) matches(endpoint1, endpoint2 string) bool {
+	return (endpointsMatch(r.Endpoint1, endpoint1) && endpointsMatch(r.Endpoint2, endpoint2)) ||
+		(endpointsMatch(r.Endpoint1, endpoint2) && endpointsMatch(r.Endpoint2, endpoint1))
+}
+
+// endpointsMatch reports whether the two given endpoints refer to the same
+// application relation. A missing relation name is inferred from the other
+// endpoint.
+func endpointsMatch(e1, e2 string) bool {
+	ep1, ep2 := parseEndpoint(strings.TrimSpace(e1)), parseEndpoint(strings.TrimSpace(e2))
+	if ep1.application != ep2.application {
+		return false
+	}
+	return ep1.relation == "" || ep2.relation == "" || ep1.relation == ep2.relation
+}
+
+// existingUnits returns the machines where the units of the given application
+// are deployed, keyed by bundle unit name ("application/index"). Model units
+// are matched to bundle units in order of unit number, so that the first
+// bundle unit is the model unit with the lowest number.
+func (m *Model) existingUnits(name string) map[string]string {
+	units := m.sortedUnits(name)
+	if units == nil {
+		return nil
+	}
+	existing := make(map[string]string, len(units))
+	for i, u := range units {
+		existing[fmt.Sprintf("%s/%d", name, i)] = u.Machine
+	}
+	return existing
+}
+
+// sortedUnits returns the units of the given application sorted by unit
+// number, or nil if the application is not present in the model.
+func (m *Model) sortedUnits(name string) []Unit {
+	app := m.application(name)
+	if app == nil {
+		return nil
+	}
+	units := make([]Unit, len(app.Units))
+	copy(units, app.Units)
+	sort.Sort(unitsByNumber(units))
+	return units
+}
+
+// inferMachineMap returns a map from bundle machine ids to model machine ids,
+// inferred from the machines where existing units are deployed. A bundle
+// machine is mapped when one of the units placed on it by the bundle already
+// exists in the model.
+func (m *Model) inferMachineMap(data *charm.BundleData) map[string]string {
+	machineMap := make(map[string]string)
+	if m == nil {
+		return machineMap
+	}
+	// Iterate over the map using its sorted keys so that results are
+	// deterministic and easier to test.
+	names := make([]string, 0, len(data.Applications))
+	for name := range data.Applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		application := data.Applications[name]
+		numPlaced := len(application.To)
+		if numPlaced == 0 {
+			continue
+		}
+		existing := m.existingUnits(name)
+		for i := 0; i < application.NumUnits; i++ {
+			machine, ok := existing[fmt.Sprintf("%s/%d", name, i)]
+			if !ok {
+				break
+			}
+			p := application.To[numPlaced-1]
+			if i < numPlaced {
+				p = application.To[i]
+			}
+			placement, err := charm.ParsePlacement(p)
+			if err != nil || placement.Machine == "" || placement.Machine == "new" {
+				continue
+			}
+			if _, ok := machineMap[placement.Machine]; !ok {
+				// Units placed in containers live in a child of the
+				// bundle machine.
+				machineMap[placement.Machine] = strings.SplitN(machine, "/", 2)[0]
+			}
+		}
+	}
+	return machineMap
+}
+
+// machineMap returns a map from bundle machine ids to model machine ids. The
+// map includes the given explicit mappings, the bundle machines having the
+// same ids as model machines if useExisting is true, and the mappings
+// inferred from the machines where existing units are deployed, in order of
+// precedence. An error is returned if an explicit mapping refers to a machine
+// not declared in the bundle or not present in the model, or if it maps a
+// bundle machine to a model machine which another bundle machine is mapped to.
+func (m *Model) machineMap(data *charm.BundleData, useExisting bool, explicit map[string]string) (map[string]string, error) {
+	machineMap := m.inferMachineMap(data)
+	if useExisting {
+		for name := range data.Machines {
+			if m.machine(name) != nil {
+				machineMap[name] = name
+			}
+		}
+	}
+	// Iterate over the map using its sorted keys so that errors are
+	// deterministic and easier to test.
+	names := make([]string, 0, len(explicit))
+	for name, _ := range explicit {
+		names = append(names, name)
+	}
+	sort.Sort(machinesByNumber(names))
+	for _, name := range names {
+		id := explicit[name]
+		if _, ok := data.Machines[name]; !ok {
+			return nil, fmt.Errorf("invalid machine mapping %q: machine %q not declared in the bundle", name+"="+id, name)
+		}
+		if m.machine(id) == nil {
+			return nil, fmt.Errorf("invalid machine mapping %q: machine %q not found in the model", name+"="+id, id)
+		}
+		machineMap[name] = id
+	}
+	mapped := make(map[string]string, len(machineMap))
+	names = make([]string, 0, len(machineMap))
+	for name, _ := range machineMap {
+		names = append(names, name)
+	}
+	sort.Sort(machinesByNumber(names))
+	for _, name := range names {
+		id := machineMap[name]
+		other, ok := mapped[id]
+		if !ok {
+			mapped[id] = name
+			continue
+		}
+		if _, ok := explicit[name]; ok {
+			return nil, fmt.Errorf("invalid machine mapping %q: machine %q is already mapped to bundle machine %q", name+"="+id, id, other)
+		}
+		if _, ok := explicit[other]; ok {
+			return nil, fmt.Errorf("invalid machine mapping %q: machine %q is already mapped to bundle machine %q", other+"="+id, id, name)
+		}
+	}
+	return machineMap, nil
+}
+
+// ParseMachineMap parses a machine map specification in the form of a comma
+// separated list of "existing" or "bundle-id=model-id" items, for instance
+// "existing,1=4". It returns whether bundle machines must be mapped to
+// existing model machines with the same ids, and the explicit mappings.
+func ParseMachineMap(spec string) (useExisting bool, machineMap map[string]string, err error) {
+	machineMap = make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+			continue
+		case item == "existing":
+			useExisting = true
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || !isMachineId(parts[0]) || !isMachineId(parts[1]) {
+			return false, nil, fmt.Errorf("invalid machine mapping %q: expected \"existing\" or \"bundle-id=model-id\"", item)
+		}
+		machineMap[parts[0]] = parts[1]
+	}
+	return useExisting, machineMap, nil
+}
+
+// isMachineId reports whether the given string is a valid top level machine
+// id.
+func isMachineId(id string) bool {
+	n, err := strconv.Atoi(id)
+	return err == nil && n >= 0 && strconv.Itoa(n) == id
+}
+
+// unitsByNumber sorts units by unit number.
+type unitsByNumber []Unit
+
+func (u unitsByNumber) Len() int      { return len(u) }
+func (u unitsByNumber) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+func (u unitsByNumber) Less(i, j int) bool {
+	return unitNumber(u[i].Name) < unitNumber(u[j].Name)
+}
+
+// unitNumber returns the number of the given unit name, for instance 2 for
+// "mysql/2", or -1 if the name is not valid.
+func unitNumber(name string) int {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return -1
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return -1
+	}
+	return n
+}
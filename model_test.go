@@ -0,0 +1,58 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/bundlechanges"
+)
+
+type modelSuite struct{}
+
+var _ = gc.Suite(&modelSuite{})
+
+var parseMachineMapTests = []struct {
+	about               string
+	spec                string
+	expectedUseExisting bool
+	expectedMachineMap  map[string]string
+	expectedError       string
+}{{
+	about:              "empty spec",
+	expectedMachineMap: map[string]string{},
+}, {
+	about:               "existing machines",
+	spec:                "existing",
+	expectedUseExisting: true,
+	expectedMachineMap:  map[string]string{},
+}, {
+	about:               "existing and explicit mappings",
+	spec:                "existing, 1=4,2=0",
+	expectedUseExisting: true,
+	expectedMachineMap:  map[string]string{"1": "4", "2": "0"},
+}, {
+	about:         "invalid mapping",
+	spec:          "existing,1",
+	expectedError: `invalid machine mapping "1": expected "existing" or "bundle-id=model-id"`,
+}, {
+	about:         "invalid machine id",
+	spec:          "1=0/lxd/0",
+	expectedError: `invalid machine mapping "1=0/lxd/0": expected "existing" or "bundle-id=model-id"`,
+}}
+
+func (s *modelSuite) TestParseMachineMap(c *gc.C) {
+	for i, test := range parseMachineMapTests {
+		c.Logf("test %d: %s", i, test.about)
+		useExisting, machineMap, err := bundlechanges.ParseMachineMap(test.spec)
+		if test.expectedError != "" {
+			c.Check(err, gc.ErrorMatches, test.expectedError)
+			continue
+		}
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(useExisting, gc.Equals, test.expectedUseExisting)
+		c.Check(machineMap, jc.DeepEquals, test.expectedMachineMap)
+	}
+}
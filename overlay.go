@@ -0,0 +1,412 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/yaml.v2"
+)
+
+// BundleOverlay holds an overlay bundle to be merged onto a base bundle.
+type BundleOverlay struct {
+	// Data holds the overlay bundle data.
+	Data *charm.BundleData
+	// NumUnits optionally holds the number of units declared in the overlay
+	// for each application, keyed by application name. It is used to tell a
+	// zero number of units apart from a number of units not declared in the
+	// overlay, as both are zero in Data.
+	NumUnits map[string]int
+	// Expose optionally holds the exposure declared in the overlay for each
+	// application, keyed by application name. It is used to tell a false
+	// exposure apart from an exposure not declared in the overlay, as both
+	// are false in Data.
+	Expose map[string]bool
+}
+
+// ReadBundleOverlay reads a YAML encoded overlay bundle from the given
+// reader. The "num_units" and "expose" fields declared in the overlay are
+// recorded even if they hold zero values, so that an overlay can scale an
+// application to zero units or unexpose it.
+func ReadBundleOverlay(r io.Reader) (*BundleOverlay, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := charm.ReadBundleData(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	var bundle struct {
+		Applications map[string]*struct {
+			NumUnits *int  `yaml:"num_units"`
+			Expose   *bool `yaml:"expose"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(content, &bundle); err != nil {
+		return nil, err
+	}
+	overlay := &BundleOverlay{
+		Data:     data,
+		NumUnits: make(map[string]int),
+		Expose:   make(map[string]bool),
+	}
+	for name, application := range bundle.Applications {
+		if application == nil {
+			continue
+		}
+		if application.NumUnits != nil {
+			overlay.NumUnits[name] = *application.NumUnits
+		}
+		if application.Expose != nil {
+			overlay.Expose[name] = *application.Expose
+		}
+	}
+	return overlay, nil
+}
+
+// MergeBundleData returns the bundle data resulting from merging the given
+// overlays, in order, onto the given base bundle data. It is equivalent to
+// MergeBundleOverlays, except that zero num_units and false expose values
+// in the overlays cannot be told apart from missing ones, and are therefore
+// ignored.
+func MergeBundleData(base *charm.BundleData, overlays ...*charm.BundleData) *charm.BundleData {
+	bundleOverlays := make([]*BundleOverlay, len(overlays))
+	for i, overlay := range overlays {
+		bundleOverlays[i] = &BundleOverlay{
+			Data: overlay,
+		}
+	}
+	return MergeBundleOverlays(base, bundleOverlays...)
+}
+
+// MergeBundleOverlays returns the bundle data resulting from merging the
+// given overlays, in order, onto the given base bundle data. Neither the base
+// nor the overlays are modified. The following rules apply:
+//
+//   - scalar values (like charm, series, constraints or num_units) declared
+//     in an overlay replace the base ones, while zero values (like empty
+//     strings) are ignored, except for the num_units and expose values
+//     recorded in the overlay NumUnits and Expose fields;
+//   - options are deep-merged, and an option with a null value is removed;
+//   - annotations, storage, bindings and resources are merged by key;
+//   - lists (like placement directives) declared in an overlay replace the
+//     base ones;
+//   - an application with a null value is removed, along with the relations
+//     involving it;
+//   - relations declared in an overlay are added to the base ones, unless
+//     already present.
+//
+// The resulting bundle data must be verified before generating changes.
+// Relations not having exactly two endpoints are preserved as they are, so
+// that they are reported when verifying the result.
+func MergeBundleOverlays(base *charm.BundleData, overlays ...*BundleOverlay) *charm.BundleData {
+	result := copyBundleData(base)
+	for _, overlay := range overlays {
+		mergeBundleData(result, overlay)
+	}
+	return result
+}
+
+// mergeBundleData merges the given overlay onto the given bundle data, which
+// is modified in place.
+func mergeBundleData(data *charm.BundleData, bundleOverlay *BundleOverlay) {
+	overlay := bundleOverlay.Data
+	if overlay.Series != "" {
+		data.Series = overlay.Series
+	}
+	if overlay.Description != "" {
+		data.Description = overlay.Description
+	}
+	for name, application := range overlay.Applications {
+		if application == nil {
+			removeApplication(data, name)
+			continue
+		}
+		existing := data.Applications[name]
+		if existing != nil {
+			mergeApplicationSpec(existing, application)
+		} else {
+			if data.Applications == nil {
+				data.Applications = make(map[string]*charm.ApplicationSpec)
+			}
+			existing = copyApplicationSpec(application)
+			data.Applications[name] = existing
+		}
+		// Zero values explicitly declared in the overlay replace the base
+		// ones.
+		if numUnits, ok := bundleOverlay.NumUnits[name]; ok {
+			existing.NumUnits = numUnits
+		}
+		if expose, ok := bundleOverlay.Expose[name]; ok {
+			existing.Expose = expose
+		}
+	}
+	for name, machine := range overlay.Machines {
+		if data.Machines == nil {
+			data.Machines = make(map[string]*charm.MachineSpec)
+		}
+		existing := data.Machines[name]
+		switch {
+		case machine == nil:
+			if _, ok := data.Machines[name]; !ok {
+				data.Machines[name] = nil
+			}
+		case existing == nil:
+			data.Machines[name] = copyMachineSpec(machine)
+		default:
+			mergeMachineSpec(existing, machine)
+		}
+	}
+	for _, relation := range overlay.Relations {
+		if !hasBundleRelation(data, relation) {
+			data.Relations = append(data.Relations, copyStrings(relation))
+		}
+	}
+}
+
+// removeApplication removes the application with the given name, and the
+// relations involving it, from the given bundle data.
+func removeApplication(data *charm.BundleData, name string) {
+	delete(data.Applications, name)
+	relations := data.Relations[:0]
+	for _, relation := range data.Relations {
+		if len(relation) != 2 {
+			// The relation is not valid, and it is reported when verifying
+			// the bundle.
+			relations = append(relations, relation)
+			continue
+		}
+		if parseEndpoint(relation[0]).application != name && parseEndpoint(relation[1]).application != name {
+			relations = append(relations, relation)
+		}
+	}
+	data.Relations = relations
+}
+
+// hasBundleRelation reports whether the given relation is already declared
+// in the given bundle data. Relations not having exactly two endpoints are
+// never considered as already declared.
+func hasBundleRelation(data *charm.BundleData, relation []string) bool {
+	if len(relation) != 2 {
+		return false
+	}
+	r := Relation{
+		Endpoint1: relation[0],
+		Endpoint2: relation[1],
+	}
+	for _, other := range data.Relations {
+		if len(other) == 2 && r.matches(other[0], other[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeApplicationSpec merges the given overlay application onto the given
+// application, which is modified in place.
+func mergeApplicationSpec(application, overlay *charm.ApplicationSpec) {
+	if overlay.Charm != "" {
+		application.Charm = overlay.Charm
+	}
+	if overlay.Series != "" {
+		application.Series = overlay.Series
+	}
+	if overlay.NumUnits != 0 {
+		application.NumUnits = overlay.NumUnits
+	}
+	if overlay.To != nil {
+		application.To = copyStrings(overlay.To)
+	}
+	if overlay.Expose {
+		application.Expose = true
+	}
+	if overlay.Constraints != "" {
+		application.Constraints = overlay.Constraints
+	}
+	application.Options = mergeOptions(application.Options, overlay.Options)
+	application.Annotations = mergeStrings(application.Annotations, overlay.Annotations)
+	application.Storage = mergeStrings(application.Storage, overlay.Storage)
+	application.EndpointBindings = mergeStrings(application.EndpointBindings, overlay.EndpointBindings)
+	for resource, revision := range overlay.Resources {
+		if application.Resources == nil {
+			application.Resources = make(map[string]int)
+		}
+		application.Resources[resource] = revision
+	}
+}
+
+// mergeMachineSpec merges the given overlay machine onto the given machine,
+// which is modified in place.
+func mergeMachineSpec(machine, overlay *charm.MachineSpec) {
+	if overlay.Series != "" {
+		machine.Series = overlay.Series
+	}
+	if overlay.Constraints != "" {
+		machine.Constraints = overlay.Constraints
+	}
+	machine.Annotations = mergeStrings(machine.Annotations, overlay.Annotations)
+}
+
+// mergeOptions deep-merges the given overlay options onto the given options,
+// and returns the result. Options with a nil value in the overlay are
+// removed. The given options are not modified.
+func mergeOptions(options, overlay map[string]interface{}) map[string]interface{} {
+	if len(overlay) == 0 {
+		return options
+	}
+	result := make(map[string]interface{}, len(options)+len(overlay))
+	for key, value := range options {
+		result[key] = value
+	}
+	for key, value := range overlay {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		result[key] = mergeOptionValue(result[key], value)
+	}
+	return result
+}
+
+// mergeOptionValue deep-merges the given overlay option value onto the given
+// value, and returns the result. Values which are not maps are replaced.
+func mergeOptionValue(value, overlay interface{}) interface{} {
+	switch overlay := overlay.(type) {
+	case map[string]interface{}:
+		if existing, ok := value.(map[string]interface{}); ok {
+			return mergeOptions(existing, overlay)
+		}
+	case map[interface{}]interface{}:
+		// Nested maps decoded from YAML have interface{} keys.
+		if existing, ok := value.(map[interface{}]interface{}); ok {
+			result := copyOptionValue(existing).(map[interface{}]interface{})
+			for key, v := range overlay {
+				if v == nil {
+					delete(result, key)
+					continue
+				}
+				result[key] = mergeOptionValue(result[key], v)
+			}
+			return result
+		}
+	}
+	return copyOptionValue(overlay)
+}
+
+// mergeStrings merges the given overlay values onto the given values by key,
+// and returns the result. The given values are not modified.
+func mergeStrings(values, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return values
+	}
+	result := make(map[string]string, len(values)+len(overlay))
+	for key, value := range values {
+		result[key] = value
+	}
+	for key, value := range overlay {
+		result[key] = value
+	}
+	return result
+}
+
+// copyBundleData returns a deep copy of the given bundle data.
+func copyBundleData(data *charm.BundleData) *charm.BundleData {
+	result := &charm.BundleData{
+		Series:      data.Series,
+		Description: data.Description,
+	}
+	if data.Applications != nil {
+		result.Applications = make(map[string]*charm.ApplicationSpec, len(data.Applications))
+		for name, application := range data.Applications {
+			result.Applications[name] = copyApplicationSpec(application)
+		}
+	}
+	if data.Machines != nil {
+		result.Machines = make(map[string]*charm.MachineSpec, len(data.Machines))
+		for name, machine := range data.Machines {
+			result.Machines[name] = copyMachineSpec(machine)
+		}
+	}
+	for _, relation := range data.Relations {
+		result.Relations = append(result.Relations, copyStrings(relation))
+	}
+	return result
+}
+
+// copyApplicationSpec returns a deep copy of the given application.
+func copyApplicationSpec(application *charm.ApplicationSpec) *charm.ApplicationSpec {
+	if application == nil {
+		return nil
+	}
+	result := *application
+	result.To = copyStrings(application.To)
+	result.Options = copyOptions(application.Options)
+	result.Annotations = mergeStrings(nil, application.Annotations)
+	result.Storage = mergeStrings(nil, application.Storage)
+	result.EndpointBindings = mergeStrings(nil, application.EndpointBindings)
+	if application.Resources != nil {
+		result.Resources = make(map[string]int, len(application.Resources))
+		for resource, revision := range application.Resources {
+			result.Resources[resource] = revision
+		}
+	}
+	return &result
+}
+
+// copyMachineSpec returns a deep copy of the given machine.
+func copyMachineSpec(machine *charm.MachineSpec) *charm.MachineSpec {
+	if machine == nil {
+		return nil
+	}
+	result := *machine
+	result.Annotations = mergeStrings(nil, machine.Annotations)
+	return &result
+}
+
+// copyOptions returns a deep copy of the given options.
+func copyOptions(options map[string]interface{}) map[string]interface{} {
+	if options == nil {
+		return nil
+	}
+	result := make(map[string]interface{}, len(options))
+	for key, value := range options {
+		result[key] = copyOptionValue(value)
+	}
+	return result
+}
+
+// copyOptionValue returns a deep copy of the given option value.
+func copyOptionValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return copyOptions(value)
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(value))
+		for k, v := range value {
+			result[k] = copyOptionValue(v)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, v := range value {
+			result[i] = copyOptionValue(v)
+		}
+		return result
+	}
+	return value
+}
+
+// copyStrings returns a copy of the given slice.
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	result := make([]string, len(values))
+	copy(result, values)
+	return result
+}
@@ -0,0 +1,354 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges_test
+
+import (
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+
+	"github.com/juju/bundlechanges"
+)
+
+type overlaySuite struct{}
+
+var _ = gc.Suite(&overlaySuite{})
+
+var mergeBundleDataTests = []struct {
+	about    string
+	base     *charm.BundleData
+	overlays []*charm.BundleData
+	expected *charm.BundleData
+}{{
+	about: "no overlays",
+	base: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:trusty/django-42", NumUnits: 1},
+		},
+	},
+	expected: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:trusty/django-42", NumUnits: 1},
+		},
+	},
+}, {
+	about: "scalar values replaced",
+	base: &charm.BundleData{
+		Series: "trusty",
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm:       "cs:django-42",
+				NumUnits:    1,
+				Constraints: "mem=2G",
+			},
+		},
+	},
+	overlays: []*charm.BundleData{{
+		Series: "xenial",
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm:    "cs:django-47",
+				NumUnits: 3,
+				Expose:   true,
+			},
+		},
+	}},
+	expected: &charm.BundleData{
+		Series: "xenial",
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm:       "cs:django-47",
+				NumUnits:    3,
+				Expose:      true,
+				Constraints: "mem=2G",
+			},
+		},
+	},
+}, {
+	about: "options deep-merged",
+	base: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm: "cs:django-42",
+				Options: map[string]interface{}{
+					"debug":   true,
+					"port":    8080,
+					"secrets": map[interface{}]interface{}{"key": "foo", "salt": "bar"},
+				},
+			},
+		},
+	},
+	overlays: []*charm.BundleData{{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Options: map[string]interface{}{
+					"debug":   nil,
+					"port":    80,
+					"secrets": map[interface{}]interface{}{"salt": nil, "token": "baz"},
+				},
+			},
+		},
+	}},
+	expected: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm: "cs:django-42",
+				Options: map[string]interface{}{
+					"port":    80,
+					"secrets": map[interface{}]interface{}{"key": "foo", "token": "baz"},
+				},
+			},
+		},
+	},
+}, {
+	about: "maps merged by key and lists replaced",
+	base: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm:       "cs:django-42",
+				NumUnits:    2,
+				To:          []string{"0", "1"},
+				Annotations: map[string]string{"gui-x": "10", "gui-y": "20"},
+				Resources:   map[string]int{"data": 1},
+			},
+		},
+		Machines: map[string]*charm.MachineSpec{
+			"0": {Series: "trusty"},
+			"1": nil,
+		},
+	},
+	overlays: []*charm.BundleData{{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				To:          []string{"lxd:0", "lxd:0"},
+				Annotations: map[string]string{"gui-y": "42"},
+				Resources:   map[string]int{"data": 2, "logs": 1},
+			},
+		},
+		Machines: map[string]*charm.MachineSpec{
+			"0": {Constraints: "cores=4"},
+		},
+	}},
+	expected: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm:       "cs:django-42",
+				NumUnits:    2,
+				To:          []string{"lxd:0", "lxd:0"},
+				Annotations: map[string]string{"gui-x": "10", "gui-y": "42"},
+				Resources:   map[string]int{"data": 2, "logs": 1},
+			},
+		},
+		Machines: map[string]*charm.MachineSpec{
+			"0": {Series: "trusty", Constraints: "cores=4"},
+			"1": nil,
+		},
+	},
+}, {
+	about: "applications removed along with their relations",
+	base: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django":    {Charm: "cs:django-42"},
+			"mysql":     {Charm: "cs:mysql-1"},
+			"memcached": {Charm: "cs:memcached-3"},
+		},
+		Relations: [][]string{
+			{"django:db", "mysql:server"},
+			{"django", "memcached"},
+		},
+	},
+	overlays: []*charm.BundleData{{
+		Applications: map[string]*charm.ApplicationSpec{
+			"memcached": nil,
+		},
+	}},
+	expected: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:django-42"},
+			"mysql":  {Charm: "cs:mysql-1"},
+		},
+		Relations: [][]string{
+			{"django:db", "mysql:server"},
+		},
+	},
+}, {
+	about: "applications and relations added by multiple overlays",
+	base: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:django-42"},
+			"mysql":  {Charm: "cs:mysql-1"},
+		},
+		Relations: [][]string{
+			{"django:db", "mysql:server"},
+		},
+	},
+	overlays: []*charm.BundleData{{
+		Applications: map[string]*charm.ApplicationSpec{
+			"haproxy": {Charm: "cs:haproxy-5", NumUnits: 1},
+		},
+		Relations: [][]string{
+			{"mysql", "django"},
+			{"haproxy:reverseproxy", "django:website"},
+		},
+	}, {
+		Applications: map[string]*charm.ApplicationSpec{
+			"haproxy": {NumUnits: 2},
+		},
+	}},
+	expected: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django":  {Charm: "cs:django-42"},
+			"mysql":   {Charm: "cs:mysql-1"},
+			"haproxy": {Charm: "cs:haproxy-5", NumUnits: 2},
+		},
+		Relations: [][]string{
+			{"django:db", "mysql:server"},
+			{"haproxy:reverseproxy", "django:website"},
+		},
+	},
+}, {
+	about: "invalid relations preserved",
+	base: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django":    {Charm: "cs:django-42"},
+			"mysql":     {Charm: "cs:mysql-1"},
+			"memcached": {Charm: "cs:memcached-3"},
+		},
+		Relations: [][]string{
+			{"django:db", "mysql:server"},
+			{"memcached"},
+		},
+	},
+	overlays: []*charm.BundleData{{
+		Applications: map[string]*charm.ApplicationSpec{
+			"mysql": nil,
+		},
+		Relations: [][]string{
+			{"django"},
+			{"django", "memcached"},
+		},
+	}},
+	expected: &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django":    {Charm: "cs:django-42"},
+			"memcached": {Charm: "cs:memcached-3"},
+		},
+		Relations: [][]string{
+			{"memcached"},
+			{"django"},
+			{"django", "memcached"},
+		},
+	},
+}}
+
+func (s *overlaySuite) TestMergeBundleData(c *gc.C) {
+	for i, test := range mergeBundleDataTests {
+		c.Logf("test %d: %s", i, test.about)
+		data := bundlechanges.MergeBundleData(test.base, test.overlays...)
+		c.Check(data, jc.DeepEquals, test.expected)
+	}
+}
+
+func (s *overlaySuite) TestMergeBundleDataDoesNotModifyBase(c *gc.C) {
+	base := &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm:   "cs:django-42",
+				To:      []string{"0"},
+				Options: map[string]interface{}{"debug": true},
+			},
+		},
+		Relations: [][]string{{"django", "mysql"}},
+	}
+	overlay := &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm:   "cs:django-47",
+				To:      []string{"1"},
+				Options: map[string]interface{}{"debug": nil},
+			},
+			"mysql": nil,
+		},
+	}
+	data := bundlechanges.MergeBundleData(base, overlay)
+	c.Assert(data.Applications["django"].Charm, gc.Equals, "cs:django-47")
+	data.Applications["django"].To[0] = "2"
+	c.Assert(base, jc.DeepEquals, &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {
+				Charm:   "cs:django-42",
+				To:      []string{"0"},
+				Options: map[string]interface{}{"debug": true},
+			},
+		},
+		Relations: [][]string{{"django", "mysql"}},
+	})
+}
+
+func (s *overlaySuite) TestMergeBundleOverlaysZeroValues(c *gc.C) {
+	base := &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:django-42", NumUnits: 3, Expose: true},
+			"mysql":  {Charm: "cs:mysql-1", NumUnits: 1, Expose: true},
+		},
+	}
+	overlay, err := bundlechanges.ReadBundleOverlay(strings.NewReader(`
+services:
+    django:
+        num_units: 0
+        expose: false
+    mysql:
+        constraints: mem=4G
+`))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(overlay.NumUnits, jc.DeepEquals, map[string]int{"django": 0})
+	c.Assert(overlay.Expose, jc.DeepEquals, map[string]bool{"django": false})
+	data := bundlechanges.MergeBundleOverlays(base, overlay)
+	c.Assert(data, jc.DeepEquals, &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:django-42"},
+			"mysql":  {Charm: "cs:mysql-1", NumUnits: 1, Expose: true, Constraints: "mem=4G"},
+		},
+	})
+}
+
+func (s *overlaySuite) TestMergeBundleDataIgnoresZeroValues(c *gc.C) {
+	base := &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:django-42", NumUnits: 3, Expose: true},
+		},
+	}
+	overlay, err := bundlechanges.ReadBundleOverlay(strings.NewReader(`
+services:
+    django:
+        num_units: 0
+        expose: false
+`))
+	c.Assert(err, jc.ErrorIsNil)
+	data := bundlechanges.MergeBundleData(base, overlay.Data)
+	c.Assert(data, jc.DeepEquals, base)
+}
+
+func (s *overlaySuite) TestMergeBundleOverlaysInvalidRelation(c *gc.C) {
+	base := &charm.BundleData{
+		Applications: map[string]*charm.ApplicationSpec{
+			"django": {Charm: "cs:django-42"},
+			"mysql":  {Charm: "cs:mysql-1"},
+		},
+		Relations: [][]string{{"django", "mysql"}},
+	}
+	overlay, err := bundlechanges.ReadBundleOverlay(strings.NewReader(`
+services:
+    mysql:
+relations:
+    - [django]
+`))
+	c.Assert(err, jc.ErrorIsNil)
+	data := bundlechanges.MergeBundleOverlays(base, overlay)
+	c.Assert(data.Relations, jc.DeepEquals, [][]string{{"django"}})
+	err = data.Verify(nil, nil)
+	c.Assert(err, gc.FitsTypeOf, &charm.VerificationError{})
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"gopkg.in/juju/charm.v6-unstable"
+)
+
+// PlacementPolicy defines how the units without placement directives are
+// placed.
+type PlacementPolicy string
+
+const (
+	// ProviderPlacement leaves the placement of units without directives to
+	// the provider.
+	ProviderPlacement PlacementPolicy = ""
+	// SpreadPlacement places each unit without directives to a new machine.
+	SpreadPlacement PlacementPolicy = "spread"
+	// PackPlacement places units without directives to the machines declared
+	// in the bundle, filling each machine up to the configured number of
+	// units before moving to the next one. New machines are added when the
+	// bundle machines are full or have a different series.
+	PackPlacement PlacementPolicy = "pack"
+)
+
+// placementHost holds a machine units without placement directives can be
+// packed onto.
+type placementHost struct {
+	// ref holds the placeholder or model id of the machine.
+	ref string
+	// series holds the series of the machine, if known.
+	series string
+	// units holds the number of units placed to the machine.
+	units int
+}
+
+// policyParent generates the changes required to place a unit without
+// placement directives according to the placement policy, and returns a
+// reference to the parent machine.
+func (p *planner) policyParent(addedMachines map[string]string, series, constraints string) string {
+	if p.placementPolicy == PackPlacement {
+		if p.hosts == nil {
+			p.hosts = p.bundleHosts(addedMachines)
+		}
+		for _, host := range p.hosts {
+			if host.series != "" && series != "" && host.series != series {
+				continue
+			}
+			if p.unitsPerMachine > 0 && host.units >= p.unitsPerMachine {
+				continue
+			}
+			host.units++
+			return host.ref
+		}
+	}
+	change := newAddMachineChange(AddMachineParams{
+		Series:      series,
+		Constraints: constraints,
+		Zone:        p.zone(),
+	})
+	p.add(change)
+	ref := "$" + change.Id()
+	if p.placementPolicy == PackPlacement {
+		p.hosts = append(p.hosts, &placementHost{
+			ref:    ref,
+			series: series,
+			units:  1,
+		})
+	}
+	return ref
+}
+
+// bundleHosts returns the machines declared in the bundle, sorted by machine
+// number, along with the number of units placed to each of them.
+func (p *planner) bundleHosts(addedMachines map[string]string) []*placementHost {
+	names := make([]string, 0, len(p.bundle.Machines))
+	for name, _ := range p.bundle.Machines {
+		names = append(names, name)
+	}
+	sort.Sort(machinesByNumber(names))
+	placed := p.placedUnits()
+	hosts := make([]*placementHost, 0, len(names))
+	for _, name := range names {
+		ref, ok := p.machineMap[name]
+		if !ok {
+			ref = "$" + addedMachines[name]
+		}
+		hosts = append(hosts, &placementHost{
+			ref:    ref,
+			series: p.placementSeries(name),
+			units:  placed[name],
+		})
+	}
+	return hosts
+}
+
+// placedUnits returns the number of units placed directly to each machine
+// declared in the bundle, keyed by bundle machine name. This includes the
+// units already deployed to the model machines bundle machines are mapped
+// to, and the units added by the bundle with a placement directive targeting
+// the machine.
+func (p *planner) placedUnits() map[string]int {
+	placed := make(map[string]int)
+	for name, application := range p.bundle.Applications {
+		existing := p.model.existingUnits(name)
+		numPlaced := len(application.To)
+		for i := 0; i < application.NumUnits && numPlaced > 0; i++ {
+			if _, ok := existing[fmt.Sprintf("%s/%d", name, i)]; ok {
+				// The unit is already deployed, and it is counted below.
+				continue
+			}
+			directive := application.To[numPlaced-1]
+			if i < numPlaced {
+				directive = application.To[i]
+			}
+			placement, err := charm.ParsePlacement(directive)
+			if err != nil || placement.ContainerType != "" || placement.Machine == "" || placement.Machine == "new" {
+				continue
+			}
+			placed[placement.Machine]++
+		}
+	}
+	// Count the units deployed to mapped model machines. Subordinate units
+	// are not counted, as they live alongside their principal units.
+	bundleMachines := make(map[string]string, len(p.machineMap))
+	for name, id := range p.machineMap {
+		bundleMachines[id] = name
+	}
+	for name, application := range p.model.Applications {
+		if p.isSubordinate(name) {
+			continue
+		}
+		for _, u := range application.Units {
+			if machine, ok := bundleMachines[u.Machine]; ok {
+				placed[machine]++
+			}
+		}
+	}
+	return placed
+}
+
+// machinesByNumber sorts machine ids by machine number. Ids which are not
+// numbers are sorted after the others, in lexical order.
+type machinesByNumber []string
+
+func (m machinesByNumber) Len() int      { return len(m) }
+func (m machinesByNumber) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m machinesByNumber) Less(i, j int) bool {
+	n1, err1 := strconv.Atoi(m[i])
+	n2, err2 := strconv.Atoi(m[j])
+	switch {
+	case err1 == nil && err2 == nil:
+		return n1 < n2
+	case err1 == nil || err2 == nil:
+		return err1 == nil
+	}
+	return m[i] < m[j]
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"os"
+	"sync"
+
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable"
+)
+
+// CharmResolver is used to retrieve information about the charms referenced
+// by a bundle.
+type CharmResolver interface {
+	// ResolveCharm returns information about the charm with the given URL or
+	// local path, as declared in the bundle. A nil info and a nil error are
+	// returned if the resolver has no information about the charm, in which
+	// case the charm is assumed to be a charm store URL.
+	ResolveCharm(ref string) (*CharmInfo, error)
+}
+
+// CharmInfo holds information about a charm.
+type CharmInfo struct {
+	// Local reports whether the charm is a local charm.
+	Local bool
+	// Series holds the default series of the charm, or an empty string if
+	// the charm does not declare a default series.
+	Series string
+	// Meta optionally holds the charm metadata.
+	Meta *charm.Meta
+	// ArchiveSHA256 holds the hex encoded SHA256 hash of the charm archive.
+	// It is only specified for local charms.
+	ArchiveSHA256 string
+}
+
+// FilesystemCharmResolver is a CharmResolver retrieving information about
+// local charms from the filesystem. Charm paths are resolved relative to the
+// current working directory. No information is returned for charm URLs and
+// for local paths which do not exist, while an error is returned if a local
+// charm cannot be read.
+type FilesystemCharmResolver struct{}
+
+// ResolveCharm implements CharmResolver.ResolveCharm.
+func (FilesystemCharmResolver) ResolveCharm(path string) (*CharmInfo, error) {
+	if !isLocalCharm(path) {
+		// This is not a local charm.
+		return nil, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	info := &CharmInfo{
+		Local: true,
+	}
+	ch, curl, err := charmrepo.NewCharmAtPath(path, "")
+	switch {
+	case charm.IsMissingSeriesError(err):
+		// The local charm path is valid but the charm doesn't declare a
+		// default series.
+		dir, err := charm.ReadCharmDir(path)
+		if err != nil {
+			return nil, err
+		}
+		info.Meta = dir.Meta()
+	case err != nil:
+		return nil, err
+	default:
+		info.Series = curl.Series
+		info.Meta = ch.Meta()
+	}
+	if info.ArchiveSHA256, err = archiveSHA256(path); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// MemoryCharmResolver is a CharmResolver returning the charm information
+// stored in the map, keyed by charm URL or path.
+type MemoryCharmResolver map[string]*CharmInfo
+
+// ResolveCharm implements CharmResolver.ResolveCharm.
+func (r MemoryCharmResolver) ResolveCharm(ref string) (*CharmInfo, error) {
+	return r[ref], nil
+}
+
+// NewCachingCharmResolver returns a CharmResolver caching the information
+// returned by the given resolver, so that each charm is only resolved once.
+// Errors are not cached. The returned resolver is safe for concurrent use.
+func NewCachingCharmResolver(r CharmResolver) CharmResolver {
+	return &cachingCharmResolver{
+		resolver: r,
+		cache:    make(map[string]*CharmInfo),
+	}
+}
+
+// cachingCharmResolver is a CharmResolver caching the information returned
+// by another resolver.
+type cachingCharmResolver struct {
+	resolver CharmResolver
+	mu       sync.Mutex
+	cache    map[string]*CharmInfo
+}
+
+// ResolveCharm implements CharmResolver.ResolveCharm.
+func (r *cachingCharmResolver) ResolveCharm(ref string) (*CharmInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, ok := r.cache[ref]; ok {
+		return info, nil
+	}
+	info, err := r.resolver.ResolveCharm(ref)
+	if err != nil {
+		return nil, err
+	}
+	r.cache[ref] = info
+	return info, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/bundlechanges"
+)
+
+type resolverSuite struct{}
+
+var _ = gc.Suite(&resolverSuite{})
+
+func (s *resolverSuite) TestMemoryCharmResolver(c *gc.C) {
+	r := bundlechanges.MemoryCharmResolver{
+		"./django": {Local: true, Series: "xenial"},
+	}
+	info, err := r.ResolveCharm("./django")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info, jc.DeepEquals, &bundlechanges.CharmInfo{Local: true, Series: "xenial"})
+
+	info, err = r.ResolveCharm("cs:trusty/django-42")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info, gc.IsNil)
+}
+
+func (s *resolverSuite) TestFilesystemCharmResolverNotLocal(c *gc.C) {
+	info, err := bundlechanges.FilesystemCharmResolver{}.ResolveCharm("cs:trusty/django-42")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info, gc.IsNil)
+}
+
+func (s *resolverSuite) TestFilesystemCharmResolverMissingPath(c *gc.C) {
+	path := filepath.Join(c.MkDir(), "django")
+	info, err := bundlechanges.FilesystemCharmResolver{}.ResolveCharm(path)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info, gc.IsNil)
+}
+
+func (s *resolverSuite) TestFilesystemCharmResolverInvalidCharm(c *gc.C) {
+	charmDir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(charmDir, "metadata.yaml"), []byte("bad: wolf: yaml"), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	info, err := bundlechanges.FilesystemCharmResolver{}.ResolveCharm(charmDir)
+	c.Assert(err, gc.NotNil)
+	c.Assert(info, gc.IsNil)
+}
+
+func (s *resolverSuite) TestCachingCharmResolver(c *gc.C) {
+	r := &countingCharmResolver{
+		CharmResolver: bundlechanges.MemoryCharmResolver{
+			"django": {Series: "xenial"},
+		},
+	}
+	cr := bundlechanges.NewCachingCharmResolver(r)
+	for i := 0; i < 3; i++ {
+		info, err := cr.ResolveCharm("django")
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(info, jc.DeepEquals, &bundlechanges.CharmInfo{Series: "xenial"})
+		info, err = cr.ResolveCharm("mysql")
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(info, gc.IsNil)
+	}
+	c.Assert(r.calls, jc.DeepEquals, []string{"django", "mysql"})
+}
+
+func (s *resolverSuite) TestCachingCharmResolverError(c *gc.C) {
+	r := &countingCharmResolver{
+		err: errors.New("bad wolf"),
+	}
+	cr := bundlechanges.NewCachingCharmResolver(r)
+	for i := 0; i < 2; i++ {
+		info, err := cr.ResolveCharm("django")
+		c.Assert(err, gc.ErrorMatches, "bad wolf")
+		c.Assert(info, gc.IsNil)
+	}
+	c.Assert(r.calls, jc.DeepEquals, []string{"django", "django"})
+}
+
+// countingCharmResolver is a charm resolver recording the charms it is
+// asked to resolve.
+type countingCharmResolver struct {
+	bundlechanges.CharmResolver
+	err   error
+	calls []string
+}
+
+func (r *countingCharmResolver) ResolveCharm(ref string) (*bundlechanges.CharmInfo, error) {
+	r.calls = append(r.calls, ref)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.CharmResolver.ResolveCharm(ref)
+}
+
+func (s *resolverSuite) TestFilesystemCharmResolverArchiveSHA256(c *gc.C) {
+	charmDir := c.MkDir()
+	metadataPath := filepath.Join(charmDir, "metadata.yaml")
+	err := ioutil.WriteFile(metadataPath, []byte(charmMeta), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	err = os.MkdirAll(filepath.Join(charmDir, ".git"), 0755)
+	c.Assert(err, jc.ErrorIsNil)
+
+	info, err := bundlechanges.FilesystemCharmResolver{}.ResolveCharm(charmDir)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info.Local, jc.IsTrue)
+	c.Assert(info.Series, gc.Equals, "precise")
+	sha := info.ArchiveSHA256
+	c.Assert(sha, gc.Matches, "[0-9a-f]{64}")
+
+	// The hash does not depend on modification times or ignored files.
+	past := time.Now().Add(-time.Hour)
+	err = os.Chtimes(metadataPath, past, past)
+	c.Assert(err, jc.ErrorIsNil)
+	err = ioutil.WriteFile(filepath.Join(charmDir, ".git", "HEAD"), []byte("ref"), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	info, err = bundlechanges.FilesystemCharmResolver{}.ResolveCharm(charmDir)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info.ArchiveSHA256, gc.Equals, sha)
+
+	// The hash changes when the charm contents change.
+	err = ioutil.WriteFile(filepath.Join(charmDir, "README"), []byte("readme"), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	info, err = bundlechanges.FilesystemCharmResolver{}.ResolveCharm(charmDir)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info.ArchiveSHA256, gc.Not(gc.Equals), sha)
+}
+
+const charmMeta = `
+name: multi-series
+summary: "That's a dummy charm with multi-series."
+description: A dummy charm.
+series:
+    - precise
+    - trusty
+`
@@ -0,0 +1,109 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"sort"
+
+	"gopkg.in/juju/charm.v6-unstable"
+)
+
+// validateSeries checks that the units of each bundle application are placed
+// to machines, or co-located with units, having the same series as the
+// application. Units placed to containers are not checked, as containers are
+// created with the application series. Invalid placement directives are
+// ignored, as they are reported when handling units. All the mismatches are
+// reported in a *SeriesErrors.
+func (p *planner) validateSeries() error {
+	var errs []*SeriesError
+	names := make([]string, 0, len(p.bundle.Applications))
+	for name, _ := range p.bundle.Applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		application := p.bundle.Applications[name]
+		directives := application.To
+		if len(directives) > application.NumUnits {
+			directives = directives[:application.NumUnits]
+		}
+		if len(directives) == 0 {
+			continue
+		}
+		series, err := p.applicationSeries(name, application)
+		if err != nil {
+			return &CharmError{
+				Application: name,
+				Charm:       application.Charm,
+				Err:         err,
+			}
+		}
+		checked := make(map[string]bool, len(directives))
+		for _, directive := range directives {
+			if checked[directive] {
+				continue
+			}
+			checked[directive] = true
+			targetSeries := p.placementSeries(directive)
+			if series != "" && targetSeries != "" && series != targetSeries {
+				errs = append(errs, &SeriesError{
+					Application:  name,
+					Series:       series,
+					Directive:    directive,
+					TargetSeries: targetSeries,
+				})
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return &SeriesErrors{
+			Errors: errs,
+		}
+	}
+	return nil
+}
+
+// placementSeries returns the series of the machine or unit targeted by the
+// given placement directive, or an empty string if the series is not known
+// or if the unit is placed to a new machine or container.
+func (p *planner) placementSeries(directive string) string {
+	placement, err := charm.ParsePlacement(directive)
+	if err != nil || placement.ContainerType != "" || placement.Machine == "new" {
+		return ""
+	}
+	if placement.Machine != "" {
+		if id, ok := p.machineMap[placement.Machine]; ok {
+			if machine := p.model.machine(id); machine != nil && machine.Series != "" {
+				return machine.Series
+			}
+		}
+		machine, ok := p.bundle.Machines[placement.Machine]
+		if !ok {
+			return ""
+		}
+		if machine != nil && machine.Series != "" {
+			return machine.Series
+		}
+		return p.bundle.Series
+	}
+	application := p.bundle.Applications[placement.Application]
+	if application == nil {
+		return ""
+	}
+	series, err := p.applicationSeries(placement.Application, application)
+	if err != nil {
+		return ""
+	}
+	return series
+}
+
+// applicationSeries returns the series of the units of the given bundle
+// application. Units of applications already present in the model are
+// deployed with the series of the existing application, if known.
+func (p *planner) applicationSeries(name string, application *charm.ApplicationSpec) (string, error) {
+	if existing := p.model.application(name); existing != nil && existing.Series != "" {
+		return existing.Series, nil
+	}
+	return p.getSeries(application)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"errors"
+	"sort"
+
+	"gopkg.in/juju/charm.v6-unstable"
+)
+
+// handleSubordinates flags the bundle applications whose charm is
+// subordinate, as reported by the charm metadata, and validates them:
+// subordinate applications cannot have units or placement directives, and
+// must be related to a principal application using a container-scoped
+// relation. Applications whose charm metadata is not available are assumed
+// to be principal.
+func (p *planner) handleSubordinates() error {
+	names := make([]string, 0, len(p.bundle.Applications))
+	for name, _ := range p.bundle.Applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		application := p.bundle.Applications[name]
+		info, err := p.charmResolver.ResolveCharm(p.charmPath(application.Charm))
+		if err != nil {
+			return &CharmError{
+				Application: name,
+				Charm:       application.Charm,
+				Err:         err,
+			}
+		}
+		if info == nil || info.Meta == nil || !info.Meta.Subordinate {
+			continue
+		}
+		p.subordinates[name] = true
+		switch {
+		case application.NumUnits != 0:
+			err = errors.New("num_units must not be specified for subordinate applications")
+		case len(application.To) != 0:
+			err = errors.New("placement directives must not be specified for subordinate applications")
+		case !p.hasContainerRelation(name, info.Meta):
+			err = errors.New("no container-scoped relation declared in the bundle")
+		}
+		if err != nil {
+			return &SubordinateError{
+				Application: name,
+				Err:         err,
+			}
+		}
+	}
+	return nil
+}
+
+// isSubordinate reports whether the given application is subordinate, either
+// because its charm metadata says so, or because the model reports it as
+// such.
+func (p *planner) isSubordinate(name string) bool {
+	if p.subordinates[name] {
+		return true
+	}
+	application := p.model.application(name)
+	return application != nil && application.Subordinate
+}
+
+// hasContainerRelation reports whether the bundle declares a relation to
+// the given subordinate application using a container-scoped endpoint of its
+// charm. When the relation name is omitted, the charm is only required to
+// declare a container-scoped relation.
+func (p *planner) hasContainerRelation(name string, meta *charm.Meta) bool {
+	for _, relation := range p.bundle.Relations {
+		for _, e := range relation {
+			ep := parseEndpoint(e)
+			if ep.application != name {
+				continue
+			}
+			if ep.relation == "" {
+				if hasContainerScope(meta.Requires) || hasContainerScope(meta.Provides) {
+					return true
+				}
+				continue
+			}
+			if r, ok := meta.Requires[ep.relation]; ok && r.Scope == charm.ScopeContainer {
+				return true
+			}
+			if r, ok := meta.Provides[ep.relation]; ok && r.Scope == charm.ScopeContainer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasContainerScope reports whether any of the given relations is
+// container-scoped.
+func hasContainerScope(relations map[string]charm.Relation) bool {
+	for _, r := range relations {
+		if r.Scope == charm.ScopeContainer {
+			return true
+		}
+	}
+	return false
+}
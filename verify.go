@@ -0,0 +1,31 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package bundlechanges
+
+import (
+	"gopkg.in/juju/charm.v6-unstable"
+)
+
+// VerifyBundleData verifies the given bundle data as done by
+// charm.BundleData.VerifyLocal, except that "zone=<zone>" placement
+// directives, which are not supported by the charm package, are accepted.
+// Local charm paths are relative to the given bundle directory. The bundle
+// data is not modified.
+func VerifyBundleData(data *charm.BundleData, bundleDir string, verifyConstraints func(c string) error, verifyStorage func(s string) error) error {
+	// Zone directives place units to new machines: verify them as such.
+	// Directives without a zone are left as they are, so that they fail
+	// verification.
+	verified := copyBundleData(data)
+	for _, application := range verified.Applications {
+		if application == nil {
+			continue
+		}
+		for i, directive := range application.To {
+			if zone, ok := parseZone(directive); ok && zone != "" {
+				application.To[i] = "new"
+			}
+		}
+	}
+	return verified.VerifyLocal(bundleDir, verifyConstraints, verifyStorage)
+}